@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
@@ -41,7 +42,7 @@ func main() {
 	dataCommandSelect := createGpioOutPin("GPIO22")
 	reset := createGpioOutPin("GPIO23")
 
-	ili9341Dev, err := ili9341.NewILI9341(spiConn, dataCommandSelect, reset)
+	ili9341Dev, err := ili9341.NewILI9341(spiConn, dataCommandSelect, reset, ili9341.ROTATION_0)
 	var ili9341Display display.RGBDisplay
 	ili9341Display = display.NewRGBDisplay(ili9341Dev)
 	checkFatalErr(err)
@@ -68,6 +69,24 @@ func main() {
 		fmt.Println("Update Duration(ms): ", time.Since(ts).Milliseconds(), ", Num of updated Segments: ", numsegs)
 		time.Sleep(time.Second / 10)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	stats, err := display.Loop(ctx, ili9341Display, 20, drawCounter)
+	checkFatalErr(err)
+	fmt.Println("Counter loop:", stats)
+}
+
+func drawCounter(ili9341Display display.RGBDisplay, frame int) error {
+	if frame == 0 {
+		ili9341Display.SetBackgroundColor(colors.WHITE)
+		ili9341Display.Clear()
+		ili9341Display.SetFont(fonts.FreeSerif24pt7b)
+	}
+	ili9341Display.ClearArea(32, 20, 287, 80)
+	ili9341Display.MoveCursor(32, 60)
+	ili9341Display.SetColor(colors.BLACK)
+	ili9341Display.Write(fmt.Sprintf("%6d", frame))
+	return nil
 }
 
 func drawLines(ili9341Display display.RGBDisplay) {
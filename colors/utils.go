@@ -26,3 +26,11 @@ func ToRGB565(color Color) (RGB565, error) {
 	}
 	return RGB888ToRGB565(c), nil
 }
+
+func ToRGB888(color Color) (RGB888, error) {
+	c, ok := color.(RGB888)
+	if !ok {
+		return BLACK, errors.New("rgb888 color type mismatch")
+	}
+	return c, nil
+}
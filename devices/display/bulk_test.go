@@ -0,0 +1,75 @@
+package display
+
+import (
+	"testing"
+
+	"github.com/marksaravi/devices-go/colors"
+)
+
+// bulkImageDevice implements bulkPixelDevice on top of imageDevice pixel by pixel.
+type bulkImageDevice struct {
+	*imageDevice
+	calls int
+}
+
+func (dev *bulkImageDevice) FillRect(x1, y1, x2, y2 int, color colors.Color) {
+	dev.calls++
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			dev.Pixel(x, y, color)
+		}
+	}
+}
+
+func (dev *bulkImageDevice) HLine(x1, x2, y int, color colors.Color) {
+	dev.FillRect(x1, y, x2, y, color)
+}
+
+func (dev *bulkImageDevice) VLine(x, y1, y2 int, color colors.Color) {
+	dev.FillRect(x, y1, x, y2, color)
+}
+
+func (dev *bulkImageDevice) Blit(x, y, width, height int, pixels []colors.Color) {
+	dev.calls++
+	for h := 0; h < height; h++ {
+		for w := 0; w < width; w++ {
+			dev.Pixel(x+w, y+h, pixels[h*width+w])
+		}
+	}
+}
+
+func TestBulkPixelDevice(t *testing.T) {
+	extra := []goldenTest{
+		{"rectangles", func(d RGBDisplay) {
+			d.FillRectangle(10.4, 20.6, 100.5, 60)
+			d.FillRectangle(300, 200, 250.2, 150.7)
+			d.ClearArea(30, 30, 50, 50)
+			d.Rectangle(0.4, 0.6, 0.6, 100.4)
+			d.Line(5, 5.2, 5.4, 5.3)
+			d.Line(200.4, 10, 200.6, 10.4)
+		}},
+	}
+	for _, test := range append(goldenTests, extra...) {
+		want := NewImageDevice(320, 240)
+		got := &bulkImageDevice{imageDevice: NewImageDevice(320, 240)}
+		for _, pixeldev := range []pixelDevice{want, got} {
+			d := NewRGBDisplay(pixeldev)
+			d.SetBackgroundColor(colors.WHITE)
+			d.Clear()
+			d.SetColor(colors.BLUE)
+			test.draw(d)
+		}
+		if got.calls == 0 {
+			t.Errorf("%s: the bulk methods are not used", test.name)
+		}
+		if _, n := diffImages(want.Image(), got.Image()); n != 0 {
+			t.Errorf("%s: %d pixels differ when drawing with the bulk methods", test.name, n)
+		}
+	}
+}
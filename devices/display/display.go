@@ -35,6 +35,16 @@ type pixelDevice interface {
 	ScreenHeight() int
 }
 
+// bulkPixelDevice is an optional extension of pixelDevice for devices that can
+// draw areas faster than pixel by pixel. Coordinates are inclusive.
+type bulkPixelDevice interface {
+	pixelDevice
+	FillRect(x1, y1, x2, y2 int, color colors.Color)
+	HLine(x1, x2, y int, color colors.Color)
+	VLine(x, y1, y2 int, color colors.Color)
+	Blit(x, y, width, height int, pixels []colors.Color)
+}
+
 type RGBDisplay interface {
 	Update() int
 	ScreenWidth() int
@@ -70,6 +80,7 @@ type RGBDisplay interface {
 
 type rgbDevice struct {
 	pixeldev        pixelDevice
+	bulkdev         bulkPixelDevice // nil if pixeldev is not a bulkPixelDevice
 	color           colors.Color
 	bgColor         colors.Color
 	font            interface{}
@@ -80,11 +91,14 @@ type rgbDevice struct {
 	charAdvanceX    int
 	textLeftPadding int
 	textTopPadding  int
+	glyphPixels     []colors.Color
 }
 
 func NewRGBDisplay(pixeldev pixelDevice) RGBDisplay {
+	bulkdev, _ := pixeldev.(bulkPixelDevice)
 	return &rgbDevice{
 		pixeldev:        pixeldev,
+		bulkdev:         bulkdev,
 		color:           colors.WHITE,
 		bgColor:         colors.BLACK,
 		fontType:        BITMAP_FONT,
@@ -101,6 +115,31 @@ func (d *rgbDevice) Update() int {
 	return d.pixeldev.Update()
 }
 
+// UpdateErr is Update with the error of the pixel device, if it reports them.
+func (d *rgbDevice) UpdateErr() (int, error) {
+	if updater, ok := d.pixeldev.(errUpdater); ok {
+		return updater.UpdateErr()
+	}
+	return d.pixeldev.Update(), nil
+}
+
+// Wait waits for the transfer of a pixel device which updates in the background.
+func (d *rgbDevice) Wait() error {
+	if waiter, ok := d.pixeldev.(transferWaiter); ok {
+		return waiter.Wait()
+	}
+	return nil
+}
+
+// BytesSent returns the number of bytes sent by the last Update, or 0 if the
+// pixel device does not count them.
+func (d *rgbDevice) BytesSent() int {
+	if counter, ok := d.pixeldev.(byteCounter); ok {
+		return counter.BytesSent()
+	}
+	return 0
+}
+
 func (d *rgbDevice) ScreenWidth() int {
 	return d.pixeldev.ScreenWidth()
 }
@@ -119,35 +158,47 @@ func (d *rgbDevice) SetColor(color colors.Color) {
 
 // Drawing methods
 func (d *rgbDevice) Clear() {
-	for x := 0; x < d.pixeldev.ScreenWidth(); x += 1 {
-		for y := 0; y < d.pixeldev.ScreenHeight(); y += 1 {
-			d.pixeldev.Pixel(x, y, d.bgColor)
-		}
-	}
+	d.fillRect(0, 0, d.pixeldev.ScreenWidth()-1, d.pixeldev.ScreenHeight()-1, d.bgColor)
 }
 
 func (d *rgbDevice) ClearArea(x1, y1, x2, y2 float64) {
-	xs:=int(math.Round(x1))
-	xe:=int(math.Round(x2))
-	ys:=int(math.Round(y1))
-	ye:=int(math.Round(y2))
-	if x1>x2 {
-		t:=xs
-		xs=xe
-		xe=t
+	xs := int(math.Round(x1))
+	xe := int(math.Round(x2))
+	ys := int(math.Round(y1))
+	ye := int(math.Round(y2))
+	if x1 > x2 {
+		xs, xe = xe, xs
 	}
-	if y1>y2 {
-		t:=ys
-		ys=ye
-		ye=t
+	if y1 > y2 {
+		ys, ye = ye, ys
 	}
-        for x := xs; x <= xe; x += 1 {
-                for y := ys; y <= ye; y += 1 {
-                        d.pixeldev.Pixel(x, y, d.bgColor)
-                }
-        }
+	d.fillRect(xs, ys, xe, ye, d.bgColor)
 }
 
+// fillRect fills the area between xs,ys and xe,ye inclusive, xs<=xe and ys<=ye.
+func (d *rgbDevice) fillRect(xs, ys, xe, ye int, color colors.Color) {
+	if d.bulkdev != nil {
+		d.bulkdev.FillRect(xs, ys, xe, ye, color)
+		return
+	}
+	for x := xs; x <= xe; x += 1 {
+		for y := ys; y <= ye; y += 1 {
+			d.pixeldev.Pixel(x, y, color)
+		}
+	}
+}
+
+func (d *rgbDevice) blit(x, y, width, height int, pixels []colors.Color) {
+	if d.bulkdev != nil {
+		d.bulkdev.Blit(x, y, width, height, pixels)
+		return
+	}
+	for h := 0; h < height; h++ {
+		for w := 0; w < width; w++ {
+			d.pixeldev.Pixel(x+w, y+h, pixels[h*width+w])
+		}
+	}
+}
 
 func (d *rgbDevice) Pixel(x, y float64) {
 	d.pixeldev.Pixel(int(math.Round(x)), int(math.Round(y)), d.color)
@@ -173,6 +224,18 @@ func (d *rgbDevice) Line(x1, y1, x2, y2 float64) {
 	}
 	err := dx + dy
 
+	if d.bulkdev != nil {
+		// horizontal and vertical lines which Bresenham draws without gaps
+		if ys == ye && (dx > 0 || xs == xe) {
+			d.bulkdev.HLine(xs, xe, ys, d.color)
+			return
+		}
+		if xs == xe && dy != 0 {
+			d.bulkdev.VLine(xs, ys, ye, d.color)
+			return
+		}
+	}
+
 	for true {
 		d.pixeldev.Pixel(xs, ys, d.color)
 		if xs == xe && ys == ye {
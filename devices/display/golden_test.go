@@ -0,0 +1,165 @@
+package display
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/utils"
+	"github.com/marksaravi/fonts-go/fonts"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+const (
+	golden_dir = "testdata/golden"
+	failed_dir = "testdata/failed"
+)
+
+type goldenTest struct {
+	name string
+	draw func(d RGBDisplay)
+}
+
+var goldenTests = []goldenTest{
+	{"line", func(d RGBDisplay) {
+		for angle := 0.0; angle < 360; angle += 15 {
+			x := math.Cos(utils.ToRad(angle)) * 110
+			y := math.Sin(utils.ToRad(angle)) * 110
+			d.Line(160, 120, 160+x, 120+y)
+		}
+	}},
+	{"arc", func(d RGBDisplay) {
+		angles := [][2]float64{
+			{0, 90}, {90, 180}, {180, 270}, {270, 360},
+			{15, 45}, {45, 15}, {105, 135}, {135, 105},
+			{195, 225}, {225, 195}, {285, 315}, {315, 285},
+		}
+		for i, a := range angles {
+			d.Arc(160, 120, float64(50+i*5), utils.ToRad(a[0]), utils.ToRad(a[1]))
+		}
+	}},
+	{"thick_arc", func(d RGBDisplay) {
+		d.ThickArc(160, 120, 70, utils.ToRad(45), utils.ToRad(175), 10, OUTER_WIDTH)
+		d.ThickArc(160, 120, 90, utils.ToRad(15), utils.ToRad(300), 10, CENTER_WIDTH)
+		d.ThickArc(160, 120, 115, utils.ToRad(300), utils.ToRad(15), 10, INNER_WIDTH)
+	}},
+	{"circle", func(d RGBDisplay) {
+		d.Circle(160, 120, 110)
+		d.Circle(160, 120, 80)
+		d.Circle(160, 120, 50.5)
+	}},
+	{"fill_circle", func(d RGBDisplay) {
+		d.FillCircle(30, 30, 45)
+		d.FillCircle(160, 120, 75)
+		d.FillCircle(300, 220, 40)
+	}},
+	{"thick_rectangle", func(d RGBDisplay) {
+		d.ThickRectangle(100, 100, 10, 10, 10, INNER_WIDTH)
+		d.ThickRectangle(50, 50, 200, 200, 10, CENTER_WIDTH)
+		d.ThickRectangle(100, 100, 300, 220, 10, OUTER_WIDTH)
+	}},
+	{"write", func(d RGBDisplay) {
+		d.SetFont(fonts.FreeSerif18pt7b)
+		d.MoveCursor(8, 40)
+		d.Write("Hello, World!")
+		d.SetFont(fonts.FreeSerif24pt7b)
+		d.MoveCursor(8, 120)
+		d.Write("123.45")
+	}},
+}
+
+func TestGolden(t *testing.T) {
+	for _, test := range goldenTests {
+		t.Run(test.name, func(t *testing.T) {
+			dev := NewImageDevice(320, 240)
+			d := NewRGBDisplay(dev)
+			d.SetBackgroundColor(colors.WHITE)
+			d.Clear()
+			d.SetColor(colors.BLUE)
+			test.draw(d)
+			checkGolden(t, test.name, dev.Image())
+		})
+	}
+}
+
+func checkGolden(t *testing.T, name string, got image.Image) {
+	t.Helper()
+	path := filepath.Join(golden_dir, name+".png")
+	if *update {
+		if err := writePNG(path, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := readPNG(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create golden files)", err)
+	}
+	diff, n := diffImages(want, got)
+	if n == 0 {
+		return
+	}
+	gotPath := filepath.Join(failed_dir, name+".got.png")
+	diffPath := filepath.Join(failed_dir, name+".diff.png")
+	if err := writePNG(gotPath, got); err != nil {
+		t.Error(err)
+	}
+	if err := writePNG(diffPath, diff); err != nil {
+		t.Error(err)
+	}
+	t.Errorf("%d pixels differ from %s, see %s and %s", n, path, gotPath, diffPath)
+}
+
+// diffImages returns an image marking differing pixels in red over a faded copy of want.
+func diffImages(want, got image.Image) (*image.RGBA, int) {
+	bounds := want.Bounds().Union(got.Bounds())
+	diff := image.NewRGBA(bounds)
+	n := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Point{x, y}
+			wc := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			gc := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			if !p.In(want.Bounds()) || !p.In(got.Bounds()) || wc != gc {
+				diff.SetRGBA(x, y, color.RGBA{R: 0xFF, A: 0xFF})
+				n++
+				continue
+			}
+			gray := uint8((uint16(wc.R) + uint16(wc.G) + uint16(wc.B)) / 3)
+			gray = 0xFF - (0xFF-gray)/4
+			diff.SetRGBA(x, y, color.RGBA{R: gray, G: gray, B: gray, A: 0xFF})
+		}
+	}
+	return diff, n
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,58 @@
+package display
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/marksaravi/devices-go/colors"
+)
+
+// imageDevice is a software pixel device that draws into an image.RGBA.
+// It is useful for rendering screens without the hardware, e.g. in tests.
+type imageDevice struct {
+	img     *image.RGBA
+	counter int
+}
+
+func NewImageDevice(width, height int) *imageDevice {
+	return &imageDevice{
+		img:     image.NewRGBA(image.Rect(0, 0, width, height)),
+		counter: 0,
+	}
+}
+
+// Update returns the number of pixels drawn since the previous Update.
+func (dev *imageDevice) Update() int {
+	counter := dev.counter
+	dev.counter = 0
+	return counter
+}
+
+func (dev *imageDevice) Pixel(x, y int, c colors.Color) {
+	if !(image.Point{x, y}).In(dev.img.Rect) {
+		return
+	}
+	rgb, _ := colors.ToRGB888(c)
+	dev.img.SetRGBA(x, y, color.RGBA{
+		R: uint8(rgb >> 16),
+		G: uint8(rgb >> 8),
+		B: uint8(rgb),
+		A: 0xFF,
+	})
+	dev.counter++
+}
+
+func (dev *imageDevice) ScreenWidth() int {
+	return dev.img.Rect.Dx()
+}
+
+func (dev *imageDevice) ScreenHeight() int {
+	return dev.img.Rect.Dy()
+}
+
+// Image returns a snapshot of the current content of the device.
+func (dev *imageDevice) Image() image.Image {
+	snapshot := image.NewRGBA(dev.img.Rect)
+	copy(snapshot.Pix, dev.img.Pix)
+	return snapshot
+}
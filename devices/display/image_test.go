@@ -0,0 +1,58 @@
+package display
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/marksaravi/devices-go/colors"
+)
+
+func TestImageDevicePixel(t *testing.T) {
+	dev := NewImageDevice(32, 24)
+	if dev.ScreenWidth() != 32 || dev.ScreenHeight() != 24 {
+		t.Fatalf("wanted 32x24, got %dx%d", dev.ScreenWidth(), dev.ScreenHeight())
+	}
+	dev.Pixel(3, 4, colors.ROYALBLUE)
+	dev.Pixel(-1, 4, colors.RED)
+	dev.Pixel(32, 4, colors.RED)
+	dev.Pixel(3, 24, colors.RED)
+
+	img := dev.Image()
+	want := color.RGBA{R: 0x41, G: 0x69, B: 0xE1, A: 0xFF}
+	if got := img.At(3, 4); got != want {
+		t.Errorf("wanted %v, got %v", want, got)
+	}
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted 1 updated pixel, got %d", n)
+	}
+	if n := dev.Update(); n != 0 {
+		t.Errorf("wanted 0 updated pixels, got %d", n)
+	}
+
+	dev.Pixel(3, 4, colors.BLACK)
+	if got := img.At(3, 4); got != want {
+		t.Errorf("snapshot changed after drawing, got %v", got)
+	}
+}
+
+func TestImageDeviceWithRGBDisplay(t *testing.T) {
+	dev := NewImageDevice(320, 240)
+	d := NewRGBDisplay(dev)
+	d.SetBackgroundColor(colors.WHITE)
+	d.Clear()
+	d.SetColor(colors.BLACK)
+	d.Line(0, 0, 319, 239)
+
+	img := dev.Image()
+	black := color.RGBA{A: 0xFF}
+	white := color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	if got := img.At(0, 0); got != black {
+		t.Errorf("wanted %v at 0,0, got %v", black, got)
+	}
+	if got := img.At(319, 239); got != black {
+		t.Errorf("wanted %v at 319,239, got %v", black, got)
+	}
+	if got := img.At(319, 0); got != white {
+		t.Errorf("wanted %v at 319,0, got %v", white, got)
+	}
+}
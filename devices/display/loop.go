@@ -0,0 +1,118 @@
+package display
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// byteCounter is an optional extension of pixelDevice for devices that know
+// how many bytes the last completed Update sent.
+type byteCounter interface {
+	BytesSent() int
+}
+
+// errUpdater is an optional extension of pixelDevice for devices whose Update
+// can fail.
+type errUpdater interface {
+	UpdateErr() (int, error)
+}
+
+// transferWaiter is an optional extension of pixelDevice for devices whose
+// Update returns before the frame is sent.
+type transferWaiter interface {
+	Wait() error
+}
+
+// LoopStats are the statistics of a render loop.
+type LoopStats struct {
+	Frames         int           // rendered frames
+	SkippedFrames  int           // frames skipped because the loop was behind
+	Updated        int           // sum of the values returned by Update
+	BytesSent      int           // bytes sent, if the device reports them
+	FrameTime      time.Duration // time to render and update the last frame
+	MaxFrameTime   time.Duration
+	TotalFrameTime time.Duration
+}
+
+// AverageFrameTime returns the average time to render and update a frame.
+func (s LoopStats) AverageFrameTime() time.Duration {
+	if s.Frames == 0 {
+		return 0
+	}
+	return s.TotalFrameTime / time.Duration(s.Frames)
+}
+
+func (s LoopStats) String() string {
+	return fmt.Sprintf("frames: %d, skipped: %d, updated: %d, bytes: %d, frame time(ms) avg: %.2f, max: %.2f",
+		s.Frames,
+		s.SkippedFrames,
+		s.Updated,
+		s.BytesSent,
+		float64(s.AverageFrameTime().Microseconds())/1000,
+		float64(s.MaxFrameTime.Microseconds())/1000,
+	)
+}
+
+// Loop calls render and Update at fps frames per second until ctx is done or
+// render or Update returns an error. frame is the number of the frame since the
+// start, frames which are missed because the loop is behind are skipped and not
+// rendered. The transfer of a device which updates in the background is waited
+// for, so the frame time and the bytes are the ones of the frame.
+func Loop(ctx context.Context, d RGBDisplay, fps int, render func(d RGBDisplay, frame int) error) (LoopStats, error) {
+	var stats LoopStats
+	if fps <= 0 {
+		return stats, errors.New("invalid fps")
+	}
+	period := time.Second / time.Duration(fps)
+	counter, _ := d.(byteCounter)
+	updater, _ := d.(errUpdater)
+	waiter, _ := d.(transferWaiter)
+	start := time.Now()
+	for frame := 0; ctx.Err() == nil; {
+		ts := time.Now()
+		if err := render(d, frame); err != nil {
+			return stats, err
+		}
+		updated := 0
+		var err error
+		if updater != nil {
+			updated, err = updater.UpdateErr()
+		} else {
+			updated = d.Update()
+		}
+		if err == nil && waiter != nil {
+			err = waiter.Wait()
+		}
+		if err != nil {
+			return stats, fmt.Errorf("frame %d: %w", frame, err)
+		}
+		stats.Updated += updated
+		if counter != nil {
+			stats.BytesSent += counter.BytesSent()
+		}
+		stats.FrameTime = time.Since(ts)
+		stats.TotalFrameTime += stats.FrameTime
+		if stats.FrameTime > stats.MaxFrameTime {
+			stats.MaxFrameTime = stats.FrameTime
+		}
+		stats.Frames++
+
+		frame++
+		next := start.Add(time.Duration(frame) * period)
+		if late := time.Since(next); late >= period {
+			skipped := int(late / period)
+			stats.SkippedFrames += skipped
+			frame += skipped
+			next = next.Add(time.Duration(skipped) * period)
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+	return stats, nil
+}
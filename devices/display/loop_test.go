@@ -0,0 +1,129 @@
+package display
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marksaravi/devices-go/colors"
+)
+
+// countingImageDevice reports 3 bytes for every pixel of the last Update.
+type countingImageDevice struct {
+	*imageDevice
+	bytesSent int
+}
+
+func (dev *countingImageDevice) Update() int {
+	n := dev.imageDevice.Update()
+	dev.bytesSent = n * 3
+	return n
+}
+
+func (dev *countingImageDevice) BytesSent() int {
+	return dev.bytesSent
+}
+
+func TestLoop(t *testing.T) {
+	d := NewRGBDisplay(&countingImageDevice{imageDevice: NewImageDevice(20, 10)})
+	ctx, cancel := context.WithCancel(context.Background())
+	frames := make([]int, 0)
+	stats, err := Loop(ctx, d, 1000, func(d RGBDisplay, frame int) error {
+		frames = append(frames, frame)
+		d.Pixel(float64(frame), 0)
+		if frame == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Frames != 3 || len(frames) != 3 {
+		t.Errorf("wanted 3 frames, got %d, %v", stats.Frames, frames)
+	}
+	if stats.Updated != 3 || stats.BytesSent != 9 {
+		t.Errorf("wanted 3 updated pixels and 9 bytes, got %d, %d", stats.Updated, stats.BytesSent)
+	}
+	if stats.MaxFrameTime < stats.FrameTime || stats.AverageFrameTime() > stats.MaxFrameTime {
+		t.Errorf("inconsistent frame times %+v", stats)
+	}
+}
+
+func TestLoopSkipsFrames(t *testing.T) {
+	d := NewRGBDisplay(NewImageDevice(20, 10))
+	frames := make([]int, 0)
+	errStop := errors.New("stop")
+	stats, err := Loop(context.Background(), d, 100, func(d RGBDisplay, frame int) error {
+		frames = append(frames, frame)
+		if len(frames) == 2 {
+			return errStop
+		}
+		d.SetColor(colors.RED)
+		d.Pixel(0, 0)
+		// miss the next 3 frames
+		time.Sleep(45 * time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("wanted the render error, got %v", err)
+	}
+	if stats.Frames != 1 || stats.SkippedFrames < 3 {
+		t.Errorf("wanted 1 frame and at least 3 skipped, got %+v", stats)
+	}
+	if len(frames) != 2 || frames[1] != 1+stats.SkippedFrames {
+		t.Errorf("wanted the skipped frames to be counted, got %v", frames)
+	}
+}
+
+var errBus = errors.New("bus failure")
+
+// asyncImageDevice counts the bytes of an Update when its transfer is waited for,
+// and fails the update of failAt.
+type asyncImageDevice struct {
+	*imageDevice
+	updates   int
+	pending   int
+	bytesSent int
+	failAt    int
+}
+
+func (dev *asyncImageDevice) UpdateErr() (int, error) {
+	dev.updates++
+	if dev.updates == dev.failAt {
+		return 0, errBus
+	}
+	dev.pending = dev.imageDevice.Update()
+	return dev.pending, nil
+}
+
+func (dev *asyncImageDevice) Wait() error {
+	dev.bytesSent = dev.pending * 3
+	return nil
+}
+
+func (dev *asyncImageDevice) BytesSent() int {
+	return dev.bytesSent
+}
+
+func TestLoopWaitsTransfers(t *testing.T) {
+	dev := &asyncImageDevice{imageDevice: NewImageDevice(20, 10), failAt: 4}
+	stats, err := Loop(context.Background(), NewRGBDisplay(dev), 1000, func(d RGBDisplay, frame int) error {
+		d.Pixel(float64(frame), 0)
+		d.Pixel(float64(frame), 1)
+		return nil
+	})
+	if !errors.Is(err, errBus) {
+		t.Errorf("wanted the update error, got %v", err)
+	}
+	if stats.Frames != 3 || stats.Updated != 6 || stats.BytesSent != 18 {
+		t.Errorf("wanted 3 frames, 6 updated pixels and 18 bytes, got %+v", stats)
+	}
+}
+
+func TestLoopInvalidFPS(t *testing.T) {
+	if _, err := Loop(context.Background(), NewRGBDisplay(NewImageDevice(2, 2)), 0, nil); err == nil {
+		t.Errorf("wanted error for 0 fps")
+	}
+}
@@ -3,6 +3,7 @@ package display
 import (
 	"errors"
 
+	"github.com/marksaravi/devices-go/colors"
 	"github.com/marksaravi/fonts-go/fonts"
 )
 
@@ -55,6 +56,10 @@ func (dev *rgbDevice) GetTextArea(text string) (x1, y1, x2, y2 int) {
 
 func (dev *rgbDevice) drawBitmapChar(char byte) {
 	glyph := dev.bitmapFont.Glyphs[char-0x20]
+	if cap(dev.glyphPixels) < glyph.Width*glyph.Height {
+		dev.glyphPixels = make([]colors.Color, glyph.Width*glyph.Height)
+	}
+	pixels := dev.glyphPixels[:glyph.Width*glyph.Height]
 	for h := 0; h < glyph.Height; h++ {
 		for w := 0; w < glyph.Width; w++ {
 			bitIndex := h*glyph.Width + w
@@ -62,15 +67,13 @@ func (dev *rgbDevice) drawBitmapChar(char byte) {
 			d := dev.bitmapFont.Bitmap[glyph.BitmapOffset+bitIndex/8]
 			mask := byte(0b10000000) >> shift
 			bit := d & mask
-			color := dev.bgColor
+			pixels[bitIndex] = dev.bgColor
 			if bit != 0 {
-				color = dev.color
+				pixels[bitIndex] = dev.color
 			}
-			x := dev.cursorX + w + glyph.XOffset
-			y := dev.cursorY + h + glyph.YOffset
-			dev.pixeldev.Pixel(x, y, color)
 		}
 	}
+	dev.blit(dev.cursorX+glyph.XOffset, dev.cursorY+glyph.YOffset, glyph.Width, glyph.Height, pixels)
 	dev.cursorX += glyph.XAdvance
 }
 
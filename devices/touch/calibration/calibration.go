@@ -0,0 +1,205 @@
+// Package calibration maps the raw readings of a resistive touch panel to the
+// coordinates of the display with an affine transform fitted on three points.
+package calibration
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+)
+
+type Rotation int
+
+// Rotation is the orientation of the display, numbered like the first four
+// ili9341 rotations: from ROTATION_0 to ROTATION_90 the top right corner of
+// the screen becomes the top left one.
+const (
+	ROTATION_0   Rotation = 0
+	ROTATION_90  Rotation = 1
+	ROTATION_180 Rotation = 2
+	ROTATION_270 Rotation = 3
+)
+
+// Point is a position on the screen or a raw reading of the panel.
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Calibration maps a raw reading to the screen of Width x Height pixels:
+//
+//	x = A*rawX + B*rawY + C
+//	y = D*rawX + E*rawY + F
+type Calibration struct {
+	A        float64  `json:"a"`
+	B        float64  `json:"b"`
+	C        float64  `json:"c"`
+	D        float64  `json:"d"`
+	E        float64  `json:"e"`
+	F        float64  `json:"f"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Rotation Rotation `json:"rotation"`
+}
+
+// Compute fits the calibration on three touches, raw[i] being the reading of a
+// touch on screen[i]. The points must not be on a line.
+func Compute(screen, raw [3]Point, width, height int, rotation Rotation) (Calibration, error) {
+	m := [3][3]float64{}
+	for i, p := range raw {
+		m[i] = [3]float64{p.X, p.Y, 1}
+	}
+	x, err := solve(m, [3]float64{screen[0].X, screen[1].X, screen[2].X})
+	if err != nil {
+		return Calibration{}, err
+	}
+	y, err := solve(m, [3]float64{screen[0].Y, screen[1].Y, screen[2].Y})
+	if err != nil {
+		return Calibration{}, err
+	}
+	c := Calibration{
+		A: x[0], B: x[1], C: x[2],
+		D: y[0], E: y[1], F: y[2],
+		Width:    width,
+		Height:   height,
+		Rotation: rotation,
+	}
+	if err := c.validate(); err != nil {
+		return Calibration{}, err
+	}
+	return c, nil
+}
+
+// solve solves m * v = b with Cramer's rule.
+func solve(m [3][3]float64, b [3]float64) ([3]float64, error) {
+	det := determinant(m)
+	if math.Abs(det) < 1e-9 {
+		return [3]float64{}, errors.New("calibration points are on a line")
+	}
+	var v [3]float64
+	for col := 0; col < 3; col++ {
+		mc := m
+		for row := 0; row < 3; row++ {
+			mc[row][col] = b[row]
+		}
+		v[col] = determinant(mc) / det
+	}
+	return v, nil
+}
+
+func determinant(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+func (c Calibration) validate() error {
+	if c.Width <= 0 || c.Height <= 0 {
+		return errors.New("invalid screen size")
+	}
+	if c.Rotation < ROTATION_0 || c.Rotation > ROTATION_270 {
+		return errors.New("invalid rotation")
+	}
+	for _, v := range []float64{c.A, c.B, c.C, c.D, c.E, c.F} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return errors.New("invalid calibration matrix")
+		}
+	}
+	if math.Abs(c.A*c.E-c.B*c.D) < 1e-12 {
+		return errors.New("invalid calibration matrix")
+	}
+	return nil
+}
+
+// MapPoint maps a raw reading to the screen without rounding or clipping.
+func (c Calibration) MapPoint(raw Point) Point {
+	return Point{
+		X: c.A*raw.X + c.B*raw.Y + c.C,
+		Y: c.D*raw.X + c.E*raw.Y + c.F,
+	}
+}
+
+// Map maps a raw reading to the nearest pixel of the screen. Touches beyond
+// the edges are moved onto them.
+func (c Calibration) Map(rawX, rawY int) (x, y int) {
+	p := c.MapPoint(Point{X: float64(rawX), Y: float64(rawY)})
+	return clip(int(math.Round(p.X)), c.Width), clip(int(math.Round(p.Y)), c.Height)
+}
+
+func clip(v, size int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= size {
+		return size - 1
+	}
+	return v
+}
+
+// ForRotation returns the calibration of the display turned to another
+// rotation. The panel does not move, only the screen coordinates change.
+func (c Calibration) ForRotation(rotation Rotation) (Calibration, error) {
+	if rotation < ROTATION_0 || rotation > ROTATION_270 {
+		return Calibration{}, errors.New("invalid rotation")
+	}
+	steps := (int(rotation) - int(c.Rotation) + 4) % 4
+	for i := 0; i < steps; i++ {
+		c = c.rotated()
+	}
+	c.Rotation = rotation
+	return c, nil
+}
+
+// rotated turns the screen a step: the point x, y becomes y, width-1-x.
+func (c Calibration) rotated() Calibration {
+	w := float64(c.Width - 1)
+	return Calibration{
+		A: c.D, B: c.E, C: c.F,
+		D: -c.A, E: -c.B, F: w - c.C,
+		Width:    c.Height,
+		Height:   c.Width,
+		Rotation: c.Rotation,
+	}
+}
+
+// Save writes the calibration as JSON.
+func (c Calibration) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// Load reads a calibration written by Save.
+func Load(r io.Reader) (Calibration, error) {
+	var c Calibration
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return Calibration{}, err
+	}
+	if err := c.validate(); err != nil {
+		return Calibration{}, err
+	}
+	return c, nil
+}
+
+func (c Calibration) SaveFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func LoadFile(path string) (Calibration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Calibration{}, err
+	}
+	defer f.Close()
+	return Load(f)
+}
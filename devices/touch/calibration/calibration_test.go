@@ -0,0 +1,218 @@
+package calibration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marksaravi/devices-go/devices/display"
+	"github.com/marksaravi/devices-go/hardware/xpt2046"
+)
+
+// toRaw is the reading of a touch on the screen of a 320x240 ROTATION_0 display,
+// with the axes of the panel swapped and the y axis reversed.
+func toRaw(x, y float64) Point {
+	return Point{X: 3900 - 14*y, Y: 250 + 11*x}
+}
+
+func testCalibration(t *testing.T) Calibration {
+	t.Helper()
+	screen := [3]Point{{20, 20}, {299, 120}, {160, 219}}
+	var raw [3]Point
+	for i, p := range screen {
+		raw[i] = toRaw(p.X, p.Y)
+	}
+	c, err := Compute(screen, raw, 320, 240, ROTATION_0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCompute(t *testing.T) {
+	c := testCalibration(t)
+	for _, p := range []Point{{0, 0}, {319, 239}, {100, 50}, {7, 200}} {
+		got := c.MapPoint(toRaw(p.X, p.Y))
+		if math.Abs(got.X-p.X) > 1e-6 || math.Abs(got.Y-p.Y) > 1e-6 {
+			t.Errorf("wanted %v, got %v", p, got)
+		}
+	}
+	raw := toRaw(100.4, 49.6)
+	if x, y := c.Map(int(raw.X), int(raw.Y)); x != 100 || y != 50 {
+		t.Errorf("wanted 100,50, got %d,%d", x, y)
+	}
+	raw = toRaw(-5, 250)
+	if x, y := c.Map(int(raw.X), int(raw.Y)); x != 0 || y != 239 {
+		t.Errorf("wanted the touch beyond the edges on 0,239, got %d,%d", x, y)
+	}
+
+	line := [3]Point{{0, 0}, {10, 10}, {20, 20}}
+	if _, err := Compute(line, line, 320, 240, ROTATION_0); err == nil {
+		t.Errorf("wanted error for points on a line")
+	}
+}
+
+func TestForRotation(t *testing.T) {
+	c := testCalibration(t)
+	tests := []struct {
+		rotation      Rotation
+		width, height int
+		x, y          float64 // where the ROTATION_0 pixel 1,2 is
+	}{
+		{ROTATION_0, 320, 240, 1, 2},
+		{ROTATION_90, 240, 320, 2, 318},
+		{ROTATION_180, 320, 240, 318, 237},
+		{ROTATION_270, 240, 320, 237, 1},
+	}
+	for _, test := range tests {
+		r, err := c.ForRotation(test.rotation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Width != test.width || r.Height != test.height || r.Rotation != test.rotation {
+			t.Errorf("rotation %d: wanted %dx%d, got %dx%d rotation %d", test.rotation, test.width, test.height, r.Width, r.Height, r.Rotation)
+		}
+		got := r.MapPoint(toRaw(1, 2))
+		if math.Abs(got.X-test.x) > 1e-6 || math.Abs(got.Y-test.y) > 1e-6 {
+			t.Errorf("rotation %d: wanted %v,%v, got %v", test.rotation, test.x, test.y, got)
+		}
+		back, _ := r.ForRotation(ROTATION_0)
+		if p := back.MapPoint(toRaw(1, 2)); math.Abs(p.X-1) > 1e-6 || math.Abs(p.Y-2) > 1e-6 {
+			t.Errorf("rotation %d: wanted 1,2 after rotating back, got %v", test.rotation, p)
+		}
+	}
+	if _, err := c.ForRotation(Rotation(4)); err == nil {
+		t.Errorf("wanted error for invalid rotation")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	c := testCalibration(t)
+	var buf bytes.Buffer
+	if err := c.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"width": 320`) {
+		t.Errorf("wanted indented JSON, got %s", buf.String())
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != c {
+		t.Errorf("wanted %v, got %v", c, loaded)
+	}
+
+	path := filepath.Join(t.TempDir(), "touch.json")
+	if err := c.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadFile(path); err != nil || loaded != c {
+		t.Errorf("wanted %v, got %v, %v", c, loaded, err)
+	}
+
+	for _, data := range []string{
+		`{"a": 1`,
+		`{"a": 0, "b": 0, "c": 0, "d": 0, "e": 0, "f": 0, "width": 320, "height": 240}`,
+		`{"a": 1, "e": 1, "width": 0, "height": 240}`,
+		`{"a": 1, "e": 1, "width": 320, "height": 240, "rotation": 5}`,
+	} {
+		if _, err := Load(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: wanted error", data)
+		}
+	}
+}
+
+// fakeTouch touches the targets one after the other, after a glitch. It checks
+// that each target is drawn before it is touched.
+type fakeTouch struct {
+	t       *testing.T
+	screen  interface{ Image() image.Image }
+	targets [3]Point
+	steps   []touchStep
+}
+
+type touchStep struct {
+	sample xpt2046.Sample
+	target int // the target to check, -1 for none
+}
+
+func newFakeTouch(t *testing.T, screen interface{ Image() image.Image }, targets [3]Point) *fakeTouch {
+	f := &fakeTouch{t: t, screen: screen, targets: targets}
+	// a single reading of a touch is ignored
+	f.steps = append(f.steps, touchStep{xpt2046.Sample{X: 100, Y: 100, Z: 1000}, -1}, touchStep{xpt2046.Sample{}, -1})
+	for i, p := range targets {
+		raw := toRaw(p.X, p.Y)
+		// the noise averages out
+		for j, noise := range []float64{-3, 3, -1, 1} {
+			check := -1
+			if j == 0 {
+				check = i
+			}
+			s := xpt2046.Sample{X: int(math.Round(raw.X + noise)), Y: int(math.Round(raw.Y - noise)), Z: 1000}
+			f.steps = append(f.steps, touchStep{s, check})
+		}
+		f.steps = append(f.steps, touchStep{xpt2046.Sample{}, -1})
+	}
+	return f
+}
+
+func (f *fakeTouch) Read() (xpt2046.Sample, error) {
+	if len(f.steps) == 0 {
+		return xpt2046.Sample{}, errors.New("no more touches")
+	}
+	step := f.steps[0]
+	f.steps = f.steps[1:]
+	if step.target >= 0 {
+		p := f.targets[step.target]
+		if c := f.screen.Image().At(int(p.X), int(p.Y)); c != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+			f.t.Errorf("target %d: wanted a crosshair at %v, got %v", step.target, p, c)
+		}
+	}
+	return step.sample, nil
+}
+
+func TestRun(t *testing.T) {
+	dev := display.NewImageDevice(320, 240)
+	config := DefaultConfig()
+	config.PollInterval = time.Millisecond
+	touch := newFakeTouch(t, dev, targets(320, 240, config.Margin))
+	c, err := Run(context.Background(), display.NewRGBDisplay(dev), touch, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []Point{{0, 0}, {319, 239}, {100, 50}} {
+		raw := toRaw(p.X, p.Y)
+		if x, y := c.Map(int(math.Round(raw.X)), int(math.Round(raw.Y))); x != int(p.X) || y != int(p.Y) {
+			t.Errorf("wanted %v, got %d,%d", p, x, y)
+		}
+	}
+	if got := dev.Image().At(20, 20); got != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Errorf("wanted the screen cleared, got %v", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Run(ctx, display.NewRGBDisplay(dev), sampler(func() (xpt2046.Sample, error) {
+		return xpt2046.Sample{}, nil
+	}), config); err != context.DeadlineExceeded {
+		t.Errorf("wanted %v, got %v", context.DeadlineExceeded, err)
+	}
+	config.Margin = 200
+	if _, err := Run(context.Background(), display.NewRGBDisplay(dev), touch, config); err == nil {
+		t.Errorf("wanted error for invalid margin")
+	}
+}
+
+type sampler func() (xpt2046.Sample, error)
+
+func (s sampler) Read() (xpt2046.Sample, error) {
+	return s()
+}
@@ -0,0 +1,118 @@
+package calibration
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/devices/display"
+	"github.com/marksaravi/devices-go/hardware/xpt2046"
+)
+
+// min_touch_samples is the number of readings of a touch which is not a glitch.
+const min_touch_samples = 3
+
+const crosshair_size = 10
+
+// Sampler reads the touch panel, the xpt2046 driver implements it.
+type Sampler interface {
+	Read() (xpt2046.Sample, error)
+}
+
+type Config struct {
+	Margin            int // distance of the targets from the edges of the screen
+	PressureThreshold int
+	PollInterval      time.Duration
+	Rotation          Rotation // rotation of the display during the calibration
+	Color             colors.Color
+	BackgroundColor   colors.Color
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Margin:            20,
+		PressureThreshold: 400,
+		PollInterval:      10 * time.Millisecond,
+		Rotation:          ROTATION_0,
+		Color:             colors.WHITE,
+		BackgroundColor:   colors.BLACK,
+	}
+}
+
+// Run draws a crosshair on three targets, one after the other, and waits for
+// each to be touched and released. The reading of a touch is the average of its
+// samples. The screen is cleared at the end.
+func Run(ctx context.Context, d display.RGBDisplay, touch Sampler, config Config) (Calibration, error) {
+	width, height := d.ScreenWidth(), d.ScreenHeight()
+	if config.Margin < 0 || 2*config.Margin >= width || 2*config.Margin >= height {
+		return Calibration{}, errors.New("invalid margin")
+	}
+	if config.PollInterval <= 0 {
+		return Calibration{}, errors.New("invalid poll interval")
+	}
+	screen := targets(width, height, config.Margin)
+	var raw [3]Point
+	for i, target := range screen {
+		drawTarget(d, target, config)
+		p, err := waitTouch(ctx, touch, config)
+		if err != nil {
+			return Calibration{}, err
+		}
+		raw[i] = p
+	}
+	d.SetBackgroundColor(config.BackgroundColor)
+	d.Clear()
+	d.Update()
+	return Compute(screen, raw, width, height, config.Rotation)
+}
+
+// targets are near the top left corner, the middle of the right edge and the
+// middle of the bottom edge, so that they are far from a line.
+func targets(width, height, margin int) [3]Point {
+	right, bottom := float64(width-1-margin), float64(height-1-margin)
+	return [3]Point{
+		{X: float64(margin), Y: float64(margin)},
+		{X: right, Y: float64(height / 2)},
+		{X: float64(width / 2), Y: bottom},
+	}
+}
+
+func drawTarget(d display.RGBDisplay, p Point, config Config) {
+	d.SetBackgroundColor(config.BackgroundColor)
+	d.Clear()
+	d.SetColor(config.Color)
+	d.Line(p.X-crosshair_size, p.Y, p.X+crosshair_size, p.Y)
+	d.Line(p.X, p.Y-crosshair_size, p.X, p.Y+crosshair_size)
+	d.Circle(p.X, p.Y, crosshair_size/2)
+	d.Update()
+}
+
+// waitTouch returns the average reading of the next touch, once it is released.
+func waitTouch(ctx context.Context, touch Sampler, config Config) (Point, error) {
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+	var sum Point
+	n := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return Point{}, ctx.Err()
+		case <-ticker.C:
+		}
+		s, err := touch.Read()
+		if err != nil {
+			return Point{}, err
+		}
+		if s.Z >= config.PressureThreshold {
+			sum.X += float64(s.X)
+			sum.Y += float64(s.Y)
+			n++
+			continue
+		}
+		if n >= min_touch_samples {
+			return Point{X: sum.X / float64(n), Y: sum.Y / float64(n)}, nil
+		}
+		sum, n = Point{}, 0
+	}
+}
@@ -0,0 +1,305 @@
+// Package gesture turns a stream of touch samples into taps, long presses,
+// drags and swipes. The recognizer only uses the time of the samples, so the
+// gestures can be tested with synthetic sequences.
+package gesture
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+type EventType int
+
+const (
+	EVENT_TAP        EventType = 0
+	EVENT_DOUBLE_TAP EventType = 1
+	EVENT_LONG_PRESS EventType = 2
+	EVENT_DRAG_START EventType = 3
+	EVENT_DRAG_MOVE  EventType = 4
+	EVENT_DRAG_END   EventType = 5
+	EVENT_SWIPE      EventType = 6
+)
+
+type Direction int
+
+const (
+	DIRECTION_NONE  Direction = 0
+	DIRECTION_LEFT  Direction = 1
+	DIRECTION_RIGHT Direction = 2
+	DIRECTION_UP    Direction = 3
+	DIRECTION_DOWN  Direction = 4
+)
+
+// Sample is a touch on the screen, Down is false when the touch is released.
+type Sample struct {
+	X    int
+	Y    int
+	Down bool
+	Time time.Time
+}
+
+// Event is a gesture at X, Y which started at StartX, StartY.
+// Direction is only set for EVENT_SWIPE.
+type Event struct {
+	Type      EventType
+	X         int
+	Y         int
+	StartX    int
+	StartY    int
+	Direction Direction
+	Time      time.Time
+}
+
+type Config struct {
+	// Debounce is the time a touch must stay released to end, shorter releases
+	// are bounces of the contact.
+	Debounce          time.Duration
+	TapDuration       time.Duration // longest touch of a tap
+	DoubleTapInterval time.Duration // longest time from the release of a tap to the next touch
+	DoubleTapDistance int
+	LongPressDuration time.Duration
+	DragDistance      int     // distance from which a touch is a drag
+	SwipeDistance     int     // shortest drag of a swipe
+	SwipeVelocity     float64 // slowest swipe in pixels per second
+	TickInterval      time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Debounce:          30 * time.Millisecond,
+		TapDuration:       300 * time.Millisecond,
+		DoubleTapInterval: 300 * time.Millisecond,
+		DoubleTapDistance: 20,
+		LongPressDuration: 600 * time.Millisecond,
+		DragDistance:      10,
+		SwipeDistance:     40,
+		SwipeVelocity:     300,
+		TickInterval:      10 * time.Millisecond,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Debounce < 0 || c.TapDuration <= 0 || c.DoubleTapInterval < 0 || c.LongPressDuration <= c.TapDuration {
+		return errors.New("invalid durations")
+	}
+	if c.DoubleTapDistance < 0 || c.DragDistance < 0 || c.SwipeDistance < c.DragDistance {
+		return errors.New("invalid distances")
+	}
+	if c.SwipeVelocity <= 0 {
+		return errors.New("invalid swipe velocity")
+	}
+	if c.TickInterval <= 0 {
+		return errors.New("invalid tick interval")
+	}
+	return nil
+}
+
+// Recognizer is not safe for concurrent use, Run owns it until it returns.
+type Recognizer struct {
+	config      Config
+	down        bool
+	start       Sample
+	last        Sample
+	dragging    bool
+	longPressed bool
+	releasing   bool      // the touch is released but may bounce
+	releasedAt  time.Time // time of the release
+	lastTap     Sample    // the last tap which can start a double tap
+	events      []Event   // the events of the current Add or Tick
+}
+
+func NewRecognizer(config Config) (*Recognizer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Recognizer{config: config}, nil
+}
+
+// Add processes a sample and returns the events it completes. The samples
+// must be in time order.
+func (r *Recognizer) Add(s Sample) []Event {
+	r.events = nil
+	r.tick(s.Time)
+	switch {
+	case s.Down && r.releasing:
+		// the release was a bounce
+		r.releasing = false
+		r.move(s)
+	case s.Down && r.down:
+		r.move(s)
+	case s.Down:
+		r.down = true
+		r.start, r.last = s, s
+		r.dragging, r.longPressed = false, false
+	case r.down && !r.releasing:
+		r.releasing = true
+		r.releasedAt = s.Time
+		r.tick(s.Time)
+	}
+	return r.events
+}
+
+// Tick returns the events which are due at now without a new sample: long
+// presses of a still touch and releases which are over the debounce time.
+func (r *Recognizer) Tick(now time.Time) []Event {
+	r.events = nil
+	r.tick(now)
+	return r.events
+}
+
+// Flush ends the current touch as if it was released after its last sample,
+// without waiting for the debounce time, and returns the events it completes.
+func (r *Recognizer) Flush() []Event {
+	r.events = nil
+	if r.down && !r.releasing {
+		r.releasing = true
+		r.releasedAt = r.last.Time
+	}
+	if r.releasing {
+		r.release()
+	}
+	return r.events
+}
+
+// Reset forgets the current touch and the last tap.
+func (r *Recognizer) Reset() {
+	*r = Recognizer{config: r.config}
+}
+
+func (r *Recognizer) tick(now time.Time) {
+	if r.releasing && now.Sub(r.releasedAt) >= r.config.Debounce {
+		r.release()
+	}
+	if r.down && !r.releasing && !r.dragging && !r.longPressed && now.Sub(r.start.Time) >= r.config.LongPressDuration {
+		r.longPressed = true
+		r.emit(EVENT_LONG_PRESS, r.last, r.start.Time.Add(r.config.LongPressDuration))
+	}
+}
+
+func (r *Recognizer) move(s Sample) {
+	moved := s.X != r.last.X || s.Y != r.last.Y
+	r.last = s
+	if r.dragging {
+		if moved {
+			r.emit(EVENT_DRAG_MOVE, s, s.Time)
+		}
+		return
+	}
+	if distance(r.start, s) > float64(r.config.DragDistance) {
+		r.dragging = true
+		r.emit(EVENT_DRAG_START, s, s.Time)
+	}
+}
+
+func (r *Recognizer) release() {
+	r.down, r.releasing = false, false
+	duration := r.releasedAt.Sub(r.start.Time)
+	switch {
+	case r.dragging:
+		r.emit(EVENT_DRAG_END, r.last, r.releasedAt)
+		d := distance(r.start, r.last)
+		if d >= float64(r.config.SwipeDistance) && d/duration.Seconds() >= r.config.SwipeVelocity {
+			r.emit(EVENT_SWIPE, r.last, r.releasedAt)
+		}
+		r.lastTap = Sample{}
+	case r.longPressed || duration > r.config.TapDuration:
+		r.lastTap = Sample{}
+	case !r.lastTap.Time.IsZero() &&
+		r.start.Time.Sub(r.lastTap.Time) <= r.config.DoubleTapInterval &&
+		distance(r.lastTap, r.start) <= float64(r.config.DoubleTapDistance):
+		r.emit(EVENT_DOUBLE_TAP, r.start, r.releasedAt)
+		r.lastTap = Sample{}
+	default:
+		r.emit(EVENT_TAP, r.start, r.releasedAt)
+		r.lastTap = r.start
+		r.lastTap.Time = r.releasedAt
+	}
+}
+
+func (r *Recognizer) emit(t EventType, at Sample, time time.Time) {
+	e := Event{
+		Type:   t,
+		X:      at.X,
+		Y:      at.Y,
+		StartX: r.start.X,
+		StartY: r.start.Y,
+		Time:   time,
+	}
+	if t == EVENT_SWIPE {
+		e.Direction = direction(at.X-r.start.X, at.Y-r.start.Y)
+	}
+	r.events = append(r.events, e)
+}
+
+// direction is the main direction of a move, y grows downwards.
+func direction(dx, dy int) Direction {
+	switch {
+	case dx == 0 && dy == 0:
+		return DIRECTION_NONE
+	case abs(dx) >= abs(dy) && dx < 0:
+		return DIRECTION_LEFT
+	case abs(dx) >= abs(dy):
+		return DIRECTION_RIGHT
+	case dy < 0:
+		return DIRECTION_UP
+	}
+	return DIRECTION_DOWN
+}
+
+func distance(a, b Sample) float64 {
+	return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Run recognizes the gestures of the samples until the context is done or the
+// samples are closed, the events are closed then. Only closing the samples
+// flushes the current touch, when the context is done the touch is left in the
+// Recognizer without sending its events. The samples must be stamped with time.Now by the caller, Run ticks with
+// the wall clock between them.
+func (r *Recognizer) Run(ctx context.Context, samples <-chan Sample) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(r.config.TickInterval)
+		defer ticker.Stop()
+		for {
+			var batch []Event
+			select {
+			case <-ctx.Done():
+				return
+			case s, ok := <-samples:
+				if !ok {
+					send(ctx, events, r.Flush())
+					return
+				}
+				batch = r.Add(s)
+			case now := <-ticker.C:
+				batch = r.Tick(now)
+			}
+			if !send(ctx, events, batch) {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// send sends the events and tells if the context is still running.
+func send(ctx context.Context, events chan<- Event, batch []Event) bool {
+	for _, e := range batch {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package gesture
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// step is a sample at ms milliseconds, or a tick if tick is true.
+type step struct {
+	ms   int
+	x, y int
+	down bool
+	tick bool
+}
+
+func down(ms, x, y int) step { return step{ms: ms, x: x, y: y, down: true} }
+func up(ms int) step         { return step{ms: ms} }
+func tick(ms int) step       { return step{ms: ms, tick: true} }
+
+func feed(r *Recognizer, steps []step) []Event {
+	events := make([]Event, 0)
+	for _, s := range steps {
+		at := t0.Add(time.Duration(s.ms) * time.Millisecond)
+		if s.tick {
+			events = append(events, r.Tick(at)...)
+			continue
+		}
+		events = append(events, r.Add(Sample{X: s.x, Y: s.y, Down: s.down, Time: at})...)
+	}
+	return events
+}
+
+func TestGestures(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+		want  []Event
+	}{
+		{"tap", []step{down(0, 50, 60), down(50, 52, 61), up(100), tick(200)},
+			[]Event{{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)}}},
+		{"bouncing tap", []step{down(0, 50, 60), up(50), down(60, 51, 60), up(100), tick(200)},
+			[]Event{{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)}}},
+		{"double tap", []step{down(0, 50, 60), up(100), down(250, 55, 62), up(300), tick(400)},
+			[]Event{
+				{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)},
+				{Type: EVENT_DOUBLE_TAP, X: 55, Y: 62, StartX: 55, StartY: 62, Time: t0.Add(300 * time.Millisecond)},
+			}},
+		{"two taps too late", []step{down(0, 50, 60), up(100), down(500, 50, 60), up(600), tick(700)},
+			[]Event{
+				{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)},
+				{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(600 * time.Millisecond)},
+			}},
+		{"two taps too far", []step{down(0, 50, 60), up(100), down(200, 100, 60), up(300), tick(400)},
+			[]Event{
+				{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)},
+				{Type: EVENT_TAP, X: 100, Y: 60, StartX: 100, StartY: 60, Time: t0.Add(300 * time.Millisecond)},
+			}},
+		{"slow press", []step{down(0, 50, 60), up(450), tick(500)}, []Event{}},
+		{"long press", []step{down(0, 50, 60), down(100, 53, 60), tick(590), tick(610), up(1000), tick(1100)},
+			[]Event{{Type: EVENT_LONG_PRESS, X: 53, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(600 * time.Millisecond)}}},
+		{"drag", []step{down(0, 50, 60), down(100, 55, 60), down(200, 70, 65), down(300, 70, 65), down(400, 90, 80), up(1000), tick(1100)},
+			[]Event{
+				{Type: EVENT_DRAG_START, X: 70, Y: 65, StartX: 50, StartY: 60, Time: t0.Add(200 * time.Millisecond)},
+				{Type: EVENT_DRAG_MOVE, X: 90, Y: 80, StartX: 50, StartY: 60, Time: t0.Add(400 * time.Millisecond)},
+				{Type: EVENT_DRAG_END, X: 90, Y: 80, StartX: 50, StartY: 60, Time: t0.Add(1000 * time.Millisecond)},
+			}},
+		{"swipe left", []step{down(0, 200, 100), down(50, 150, 105), down(100, 100, 110), up(120), tick(200)},
+			[]Event{
+				{Type: EVENT_DRAG_START, X: 150, Y: 105, StartX: 200, StartY: 100, Time: t0.Add(50 * time.Millisecond)},
+				{Type: EVENT_DRAG_MOVE, X: 100, Y: 110, StartX: 200, StartY: 100, Time: t0.Add(100 * time.Millisecond)},
+				{Type: EVENT_DRAG_END, X: 100, Y: 110, StartX: 200, StartY: 100, Time: t0.Add(120 * time.Millisecond)},
+				{Type: EVENT_SWIPE, X: 100, Y: 110, StartX: 200, StartY: 100, Direction: DIRECTION_LEFT, Time: t0.Add(120 * time.Millisecond)},
+			}},
+		{"swipe up", []step{down(0, 100, 200), down(100, 102, 100), up(150), tick(200)},
+			[]Event{
+				{Type: EVENT_DRAG_START, X: 102, Y: 100, StartX: 100, StartY: 200, Time: t0.Add(100 * time.Millisecond)},
+				{Type: EVENT_DRAG_END, X: 102, Y: 100, StartX: 100, StartY: 200, Time: t0.Add(150 * time.Millisecond)},
+				{Type: EVENT_SWIPE, X: 102, Y: 100, StartX: 100, StartY: 200, Direction: DIRECTION_UP, Time: t0.Add(150 * time.Millisecond)},
+			}},
+		{"long press then drag", []step{down(0, 50, 60), tick(700), down(800, 80, 60), up(900), tick(1000)},
+			[]Event{
+				{Type: EVENT_LONG_PRESS, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(600 * time.Millisecond)},
+				{Type: EVENT_DRAG_START, X: 80, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(800 * time.Millisecond)},
+				{Type: EVENT_DRAG_END, X: 80, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(900 * time.Millisecond)},
+			}},
+	}
+	for _, test := range tests {
+		r, err := NewRecognizer(DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := feed(r, test.steps)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: wanted %d events, got %v", test.name, len(test.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: at %d, wanted %v, got %v", test.name, i, test.want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestDirection(t *testing.T) {
+	tests := []struct {
+		dx, dy int
+		want   Direction
+	}{
+		{0, 0, DIRECTION_NONE},
+		{-10, 3, DIRECTION_LEFT},
+		{10, -3, DIRECTION_RIGHT},
+		{3, -10, DIRECTION_UP},
+		{-3, 10, DIRECTION_DOWN},
+	}
+	for _, test := range tests {
+		if got := direction(test.dx, test.dy); got != test.want {
+			t.Errorf("%d,%d: wanted %d, got %d", test.dx, test.dy, test.want, got)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.LongPressDuration = config.TapDuration
+	if _, err := NewRecognizer(config); err == nil {
+		t.Errorf("wanted error for a long press shorter than a tap")
+	}
+	config = DefaultConfig()
+	config.SwipeDistance = config.DragDistance - 1
+	if _, err := NewRecognizer(config); err == nil {
+		t.Errorf("wanted error for a swipe shorter than a drag")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+		want  []Event
+	}{
+		{"nothing", []step{}, []Event{}},
+		{"released tap", []step{down(0, 50, 60), up(100)},
+			[]Event{{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)}}},
+		{"touch", []step{down(0, 50, 60), down(100, 52, 60)},
+			[]Event{{Type: EVENT_TAP, X: 50, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(100 * time.Millisecond)}}},
+		{"drag", []step{down(0, 50, 60), down(500, 90, 60)},
+			[]Event{
+				{Type: EVENT_DRAG_START, X: 90, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(500 * time.Millisecond)},
+				{Type: EVENT_DRAG_END, X: 90, Y: 60, StartX: 50, StartY: 60, Time: t0.Add(500 * time.Millisecond)},
+			}},
+	}
+	for _, test := range tests {
+		r, err := NewRecognizer(DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := append(feed(r, test.steps), r.Flush()...)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: wanted %d events, got %v", test.name, len(test.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: at %d, wanted %v, got %v", test.name, i, test.want[i], got[i])
+			}
+		}
+		if more := r.Flush(); len(more) != 0 {
+			t.Errorf("%s: wanted nothing to flush twice, got %v", test.name, more)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	config := DefaultConfig()
+	config.TickInterval = time.Millisecond
+	r, err := NewRecognizer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	samples := make(chan Sample)
+	events := r.Run(ctx, samples)
+	now := time.Now()
+	samples <- Sample{X: 10, Y: 20, Down: true, Time: now}
+	samples <- Sample{X: 10, Y: 20, Time: now.Add(50 * time.Millisecond)}
+	select {
+	case e := <-events:
+		if e.Type != EVENT_TAP || e.X != 10 || e.Y != 20 {
+			t.Errorf("wanted a tap at 10,20, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wanted the tap after the debounce time")
+	}
+	// the drag ends when the samples are closed
+	samples <- Sample{X: 10, Y: 20, Down: true, Time: now.Add(time.Second)}
+	samples <- Sample{X: 60, Y: 20, Down: true, Time: now.Add(2 * time.Second)}
+	close(samples)
+	got := make([]EventType, 0)
+	for e := range events {
+		got = append(got, e.Type)
+	}
+	if len(got) < 2 || got[len(got)-2] != EVENT_DRAG_START || got[len(got)-1] != EVENT_DRAG_END {
+		t.Errorf("wanted the drag ended after the samples are closed, got %v", got)
+	}
+}
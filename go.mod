@@ -1,6 +1,6 @@
 module github.com/marksaravi/devices-go
 
-go 1.17
+go 1.20
 
 require periph.io/x/conn/v3 v3.6.10
 
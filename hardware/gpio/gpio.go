@@ -16,3 +16,9 @@ type GPIOPinOut interface {
 type GPIOPinIn interface {
 	Read() Level
 }
+
+// GPIOPinPWM is an output driven with pulse width modulation.
+// The duty cycle is from 0, always low, to 1, always high.
+type GPIOPinPWM interface {
+	PWM(duty float64) error
+}
@@ -0,0 +1,79 @@
+// Package gpiotest provides fake GPIO pins for testing drivers without hardware.
+package gpiotest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+// Pin is a fake pin that implements both gpio.GPIOPinOut and gpio.GPIOPinIn.
+// Every level written with Out is recorded.
+type Pin struct {
+	mu      sync.Mutex
+	level   gpio.Level
+	history []gpio.Level
+}
+
+func NewPin(level gpio.Level) *Pin {
+	return &Pin{
+		level:   level,
+		history: make([]gpio.Level, 0),
+	}
+}
+
+func (p *Pin) Out(level gpio.Level) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.level = level
+	p.history = append(p.history, level)
+}
+
+func (p *Pin) Read() gpio.Level {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.level
+}
+
+// Set changes the level of the pin without recording it, e.g. to simulate an input.
+func (p *Pin) Set(level gpio.Level) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.level = level
+}
+
+// History returns the levels written with Out in order.
+func (p *Pin) History() []gpio.Level {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	history := make([]gpio.Level, len(p.history))
+	copy(history, p.history)
+	return history
+}
+
+// PWMPin is a fake gpio.GPIOPinPWM which keeps the last duty cycle.
+type PWMPin struct {
+	mu   sync.Mutex
+	duty float64
+}
+
+func NewPWMPin() *PWMPin {
+	return &PWMPin{}
+}
+
+func (p *PWMPin) PWM(duty float64) error {
+	if duty < 0 || duty > 1 {
+		return errors.New("invalid duty cycle")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.duty = duty
+	return nil
+}
+
+func (p *PWMPin) Duty() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.duty
+}
@@ -0,0 +1,6 @@
+package i2c
+
+// I2C is a bus. Tx writes w to the device at addr and then reads into r.
+type I2C interface {
+	Tx(addr uint16, w, r []byte) error
+}
@@ -0,0 +1,83 @@
+// Package i2ctest provides a recording fake I2C bus for testing drivers without hardware.
+package i2ctest
+
+import (
+	"sync"
+
+	"github.com/marksaravi/devices-go/hardware/i2c"
+)
+
+// Transaction is a single recorded Tx call.
+type Transaction struct {
+	Addr uint16
+	W    []byte
+	R    []byte
+	Err  error // error returned by Tx
+}
+
+// I2C is a fake i2c.I2C that records every transaction.
+// If a device is attached, transfers are forwarded to it so it can fill the read buffer.
+type I2C struct {
+	mu           sync.Mutex
+	device       i2c.I2C
+	transactions []Transaction
+	failAfter    int
+	failErr      error
+}
+
+// NewI2C creates a fake I2C bus, device is optional.
+func NewI2C(device i2c.I2C) *I2C {
+	return &I2C{
+		device:       device,
+		transactions: make([]Transaction, 0),
+	}
+}
+
+func (b *I2C) Tx(addr uint16, w, r []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := Transaction{
+		Addr: addr,
+		W:    append([]byte{}, w...),
+	}
+	if b.failErr != nil && b.failAfter == 0 {
+		t.Err = b.failErr
+		b.transactions = append(b.transactions, t)
+		return t.Err
+	}
+	b.failAfter--
+	if b.device != nil {
+		t.Err = b.device.Tx(addr, w, r)
+	}
+	if r != nil {
+		t.R = append([]byte{}, r...)
+	}
+	b.transactions = append(b.transactions, t)
+	return t.Err
+}
+
+// FailAfter makes every transaction after the next n ones fail with err.
+// Failed transactions are recorded but not forwarded to the device.
+// A nil err stops the failures.
+func (b *I2C) FailAfter(n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failAfter = n
+	b.failErr = err
+}
+
+// Transactions returns the recorded transactions in order.
+func (b *I2C) Transactions() []Transaction {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	transactions := make([]Transaction, len(b.transactions))
+	copy(transactions, b.transactions)
+	return transactions
+}
+
+// Reset clears the recorded transactions.
+func (b *I2C) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transactions = b.transactions[:0]
+}
@@ -0,0 +1,55 @@
+package i2ctest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type echoDevice struct{}
+
+func (echoDevice) Tx(addr uint16, w, r []byte) error {
+	copy(r, w)
+	return nil
+}
+
+func TestTransactions(t *testing.T) {
+	bus := NewI2C(echoDevice{})
+	bus.Tx(0x3C, []byte{0x00, 0xAF}, nil)
+	r := make([]byte, 1)
+	bus.Tx(0x48, []byte{0x20}, r)
+
+	if r[0] != 0x20 {
+		t.Errorf("wanted the attached device to fill the read buffer, got %x", r[0])
+	}
+	transactions := bus.Transactions()
+	if len(transactions) != 2 {
+		t.Fatalf("wanted 2 transactions, got %d", len(transactions))
+	}
+	if transactions[0].Addr != 0x3C || !bytes.Equal(transactions[0].W, []byte{0x00, 0xAF}) {
+		t.Errorf("wanted 3c 00af, got %x %x", transactions[0].Addr, transactions[0].W)
+	}
+	if transactions[1].Addr != 0x48 || !bytes.Equal(transactions[1].R, []byte{0x20}) {
+		t.Errorf("read buffer is not recorded, got %x %x", transactions[1].Addr, transactions[1].R)
+	}
+	bus.Reset()
+	if len(bus.Transactions()) != 0 {
+		t.Errorf("wanted no transactions after reset")
+	}
+}
+
+func TestFailAfter(t *testing.T) {
+	bus := NewI2C(nil)
+	errBus := errors.New("nack")
+	bus.FailAfter(1, errBus)
+	if err := bus.Tx(0x3C, []byte{0x00}, nil); err != nil {
+		t.Errorf("wanted the first transaction to succeed, got %v", err)
+	}
+	if err := bus.Tx(0x3C, []byte{0x00}, nil); err != errBus {
+		t.Errorf("wanted %v, got %v", errBus, err)
+	}
+	bus.FailAfter(0, nil)
+	if err := bus.Tx(0x3C, []byte{0x00}, nil); err != nil {
+		t.Errorf("wanted no error after the failures are stopped, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package ili9341
+
+import "github.com/marksaravi/devices-go/colors"
+
+// FillRect fills the area between x1,y1 and x2,y2 inclusive, writing straight into the segments.
+func (dev *Device) FillRect(x1, y1, x2, y2 int, color colors.Color) {
+	c, _ := colors.ToRGB565(color)
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	if x1 < 0 {
+		x1 = 0
+	}
+	if y1 < 0 {
+		y1 = 0
+	}
+	if x2 >= dev.width {
+		x2 = dev.width - 1
+	}
+	if y2 >= dev.height {
+		y2 = dev.height - 1
+	}
+	if x1 > x2 || y1 > y2 {
+		return
+	}
+	rgbcolor := dev.toPanelColor(c)
+	hi, lo := byte(rgbcolor>>8), byte(rgbcolor)
+	for _, r := range dev.scrolledRectangles(rectangle{x1, y1, x2, y2}) {
+		changed := rectangle{x1: dev.width, y1: dev.height, x2: -1, y2: -1}
+		for y := r.y1; y <= r.y2; y++ {
+			if xs, xe := dev.fillRow(r.x1, r.x2, y, hi, lo); xs <= xe {
+				changed = changed.union(rectangle{xs, y, xe, y})
+			}
+		}
+		if dev.config.DirtyTracking == DIRTY_RECTANGLES && changed.x1 <= changed.x2 {
+			dev.markRectangle(changed)
+		}
+	}
+}
+
+func (dev *Device) HLine(x1, x2, y int, color colors.Color) {
+	dev.FillRect(x1, y, x2, y, color)
+}
+
+func (dev *Device) VLine(x, y1, y2 int, color colors.Color) {
+	dev.FillRect(x, y1, x, y2, color)
+}
+
+// Blit draws width x height pixels, row by row, with the top left corner at x,y.
+func (dev *Device) Blit(x, y, width, height int, pixels []colors.Color) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	for h := 0; h < height; h++ {
+		for w := 0; w < width; w++ {
+			c, _ := colors.ToRGB565(pixels[h*width+w])
+			dev.pixel(x+w, y+h, c)
+		}
+	}
+}
+
+// fillRow fills x1..x2 of the row y, which must be on the screen, and returns
+// the range of changed pixels. xs>xe if nothing is changed.
+func (dev *Device) fillRow(x1, x2, y int, hi, lo byte) (xs, xe int) {
+	xs, xe = x2+1, x1-1
+	dev.stats.PixelsWritten += x2 - x1 + 1
+	for x := x1; x <= x2; {
+		n := dev.segmentWidth - x%dev.segmentWidth
+		if x+n-1 > x2 {
+			n = x2 - x + 1
+		}
+		i := dev.offset(x, y)
+		row := dev.segments[i : i+n*2]
+		first, last, changed := -1, -1, 0
+		for j := 0; j < len(row); j += 2 {
+			if row[j] != hi || row[j+1] != lo {
+				if first < 0 {
+					first = j
+				}
+				last = j
+				changed++
+				row[j] = hi
+				row[j+1] = lo
+			}
+		}
+		if changed > 0 {
+			dev.stats.PixelsChanged += changed
+			if x+first/2 < xs {
+				xs = x + first/2
+			}
+			xe = x + last/2
+			if dev.config.DirtyTracking == DIRTY_SEGMENTS {
+				dev.isSegmentChanged[dev.segment(x, y)] = true
+			}
+		}
+		x += n
+	}
+	return xs, xe
+}
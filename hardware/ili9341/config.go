@@ -0,0 +1,131 @@
+package ili9341
+
+import (
+	"errors"
+	"time"
+)
+
+type ColorOrder int
+
+// ColorOrder is the order of the colour filter of the panel.
+// Most ILI9341 modules are BGR, in that case the driver swaps red and blue.
+const (
+	COLOR_ORDER_BGR ColorOrder = 0
+	COLOR_ORDER_RGB ColorOrder = 1
+)
+
+type Gamma [15]byte
+
+type DirtyTracking int
+
+// DirtyTracking selects how the driver keeps track of the changed pixels.
+// DIRTY_SEGMENTS sends whole segments of the fixed segment grid and
+// DIRTY_RECTANGLES sends a small set of bounding rectangles of the changed pixels.
+const (
+	DIRTY_SEGMENTS   DirtyTracking = 0
+	DIRTY_RECTANGLES DirtyTracking = 1
+)
+
+// Config describes the panel. Width, Height and segment sizes are for ROTATION_0
+// and are swapped for the portrait rotations.
+type Config struct {
+	Width         int
+	Height        int
+	SegmentWidth  int
+	SegmentHeight int
+	ColorOrder    ColorOrder
+	Controller    Controller
+	InitSequence  InitSequence // replaces the built-in sequence of the Controller if not nil
+	Tuning        PanelTuning
+	SPIChunkSize  int // maximum number of bytes in a single SPI transfer
+	ResetDelay    time.Duration
+	SleepDelay    time.Duration // minimum time between Sleep Out and Sleep In
+	Rotation      Rotation
+	DirtyTracking DirtyTracking
+	// MemoryColumns and MemoryRows are the size of the controller memory when the
+	// panel is smaller, ColumnOffset and RowOffset locate the panel in it. Panel
+	// columns and rows are Height columns of Width rows, 0 means the panel size.
+	MemoryColumns int
+	MemoryRows    int
+	ColumnOffset  int
+	RowOffset     int
+	// DoubleBuffered makes Update hand the frame to a background transfer and
+	// return, while drawing continues in a second buffer.
+	DoubleBuffered bool
+}
+
+func DefaultConfig() Config {
+	tuning, _ := PresetTuning(TUNING_DEFAULT)
+	return Config{
+		Width:         320,
+		Height:        240,
+		SegmentWidth:  32,
+		SegmentHeight: 24,
+		ColorOrder:    COLOR_ORDER_BGR,
+		Controller:    CONTROLLER_ILI9341,
+		Tuning:        tuning,
+		SPIChunkSize:  4096,
+		ResetDelay:    120 * time.Millisecond,
+		SleepDelay:    120 * time.Millisecond,
+		Rotation:      ROTATION_0,
+		DirtyTracking: DIRTY_SEGMENTS,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Width <= 0 || c.Height <= 0 || c.Width > 0xFFFF || c.Height > 0xFFFF {
+		return errors.New("invalid screen size")
+	}
+	if c.SegmentWidth <= 0 || c.SegmentHeight <= 0 {
+		return errors.New("invalid segment size")
+	}
+	if c.Width%c.SegmentWidth != 0 || c.Height%c.SegmentHeight != 0 {
+		return errors.New("segment size does not divide the screen size")
+	}
+	memoryColumns, memoryRows := c.memorySize()
+	if memoryColumns > 0xFFFF || memoryRows > 0xFFFF {
+		return errors.New("invalid memory size")
+	}
+	if c.ColumnOffset < 0 || c.RowOffset < 0 || c.ColumnOffset+c.Height > memoryColumns || c.RowOffset+c.Width > memoryRows {
+		return errors.New("panel does not fit in the memory")
+	}
+	if c.ColorOrder != COLOR_ORDER_BGR && c.ColorOrder != COLOR_ORDER_RGB {
+		return errors.New("invalid color order")
+	}
+	if c.SPIChunkSize <= 0 {
+		return errors.New("invalid spi chunk size")
+	}
+	if c.ResetDelay < 0 {
+		return errors.New("invalid reset delay")
+	}
+	if c.SleepDelay < 0 {
+		return errors.New("invalid sleep delay")
+	}
+	if _, ok := memory_access_controls[c.Rotation]; !ok {
+		return errors.New("invalid rotation")
+	}
+	if c.InitSequence == nil {
+		if _, err := BuiltinInitSequence(c); err != nil {
+			return err
+		}
+	}
+	if err := c.Tuning.validate(); err != nil {
+		return err
+	}
+	if c.DirtyTracking != DIRTY_SEGMENTS && c.DirtyTracking != DIRTY_RECTANGLES {
+		return errors.New("invalid dirty tracking")
+	}
+	return nil
+}
+
+// memorySize returns the columns and rows of the controller memory.
+func (c Config) memorySize() (columns, rows int) {
+	columns, rows = c.MemoryColumns, c.MemoryRows
+	if columns == 0 {
+		columns = c.Height
+	}
+	if rows == 0 {
+		rows = c.Width
+	}
+	return columns, rows
+}
@@ -1,6 +1,8 @@
 package ili9341
 
 import (
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/marksaravi/devices-go/colors"
@@ -8,214 +10,380 @@ import (
 	"github.com/marksaravi/devices-go/hardware/spi"
 )
 
+type Rotation int
+
+// Rotations are clockwise relative to ROTATION_0, the landscape orientation.
+// Mirrored rotations flip the screen horizontally.
 const (
-	lcd_width                int  = 320 //LCD width
-	lcd_height               int  = 240 //LCD height
-	segment_width            int  = 32
-	segment_height           int  = 24
-	num_x_seg                int  = lcd_width / segment_width
-	num_y_seg                int  = lcd_height / segment_height
-	num_of_segments          int  = num_x_seg * num_y_seg
-	bytes_per_segments       int  = segment_width * segment_height * 2
-	row_address_order        byte = 1
-	column_address_order     byte = 0
-	row_col_exchange         byte = 1
-	vertical_refresh_order   byte = 0
-	rgb_bgr_order            byte = 0 // 0 RGB
-	horizontal_refresh_order byte = 0
-	memeory_access_control   byte = (row_address_order << 7) |
-		(column_address_order << 6) |
-		(row_col_exchange << 5) |
-		(vertical_refresh_order << 4) |
-		(rgb_bgr_order << 3) |
-		(horizontal_refresh_order << 2) | 0b00000000
+	ROTATION_0 Rotation = iota
+	ROTATION_90
+	ROTATION_180
+	ROTATION_270
+	ROTATION_0_MIRRORED
+	ROTATION_90_MIRRORED
+	ROTATION_180_MIRRORED
+	ROTATION_270_MIRRORED
 )
 
-type device struct {
-	conn             spi.SPI
-	pinDC            gpio.GPIOPinOut // WriteDataByte/writeCommand
-	pinRST           gpio.GPIOPinOut // Reset
-	segments         []byte
-	isSegmentChanged []bool
+const (
+	row_address_order        byte = 1 << 7
+	column_address_order     byte = 1 << 6
+	row_col_exchange         byte = 1 << 5
+	vertical_refresh_order   byte = 1 << 4
+	rgb_bgr_order            byte = 1 << 3
+	horizontal_refresh_order byte = 1 << 2
+)
+
+var memory_access_controls = map[Rotation]byte{
+	ROTATION_0:            row_address_order | row_col_exchange,
+	ROTATION_90:           row_address_order | column_address_order,
+	ROTATION_180:          column_address_order | row_col_exchange,
+	ROTATION_270:          0,
+	ROTATION_0_MIRRORED:   row_address_order | column_address_order | row_col_exchange,
+	ROTATION_90_MIRRORED:  row_address_order,
+	ROTATION_180_MIRRORED: row_col_exchange,
+	ROTATION_270_MIRRORED: column_address_order,
+}
+
+// Device is the ILI9341 driver. The drivers of compatible controllers return it too.
+type Device struct {
+	mu     sync.Mutex // protects the drawing state
+	busMu  sync.Mutex // protects the SPI bus and its buffers
+	conn   spi.SPI
+	pinDC  gpio.GPIOPinOut // WriteDataByte/writeCommand
+	pinRST gpio.GPIOPinOut // Reset
+	config Config
+	screenLayout
+	rotation Rotation
+	*frame          // the frame being drawn
+	front    *frame // the frame being sent, only with DoubleBuffered
+	transfer *transfer
+	// transferErr is the error of the last asynchronous transfer, until it is reported
+	transferErr    error
+	cmdBuf         [1]byte
+	dataBuf        []byte
+	resBuf         []byte
+	streamBuf      []byte
+	windows        []segmentWindow
+	isSegmentTaken []bool
+	stats          FrameStats
+	lastStats      FrameStats
+	bytesSent      int
+	scroll         scrollArea
+	scrollRects    []rectangle
+	sleepOutAt     time.Time
+	now            func() time.Time // the clock of the reset, init and sleep delays
+	sleep          func(time.Duration)
+	backlight      func(brightness float64) error
+}
+
+type screenLayout struct {
+	width           int
+	height          int
+	segmentWidth    int
+	segmentHeight   int
+	numXSeg         int
+	numYSeg         int
+	bytesPerSegment int
+	// xAddress and yAddress are added to the screen coordinates to address the memory
+	xAddress int
+	yAddress int
 }
 
 func NewILI9341(
 	spiConn spi.SPI,
 	pinDC gpio.GPIOPinOut,
 	pinRST gpio.GPIOPinOut,
-) (*device, error) {
-	d := &device{
-		conn:             spiConn,
-		pinDC:            pinDC,
-		pinRST:           pinRST,
-		segments:         make([]byte, num_of_segments*bytes_per_segments),
-		isSegmentChanged: make([]bool, num_of_segments),
-	}
-	d.initLCD()
+	rotation Rotation,
+) (*Device, error) {
+	config := DefaultConfig()
+	config.Rotation = rotation
+	return NewILI9341WithConfig(spiConn, pinDC, pinRST, config)
+}
+
+func NewILI9341WithConfig(
+	spiConn spi.SPI,
+	pinDC gpio.GPIOPinOut,
+	pinRST gpio.GPIOPinOut,
+	config Config,
+) (*Device, error) {
+	return newDevice(spiConn, pinDC, pinRST, config, time.Now, time.Sleep)
+}
+
+// newDevice creates the driver with the clock of its reset, init and sleep delays.
+func newDevice(
+	spiConn spi.SPI,
+	pinDC gpio.GPIOPinOut,
+	pinRST gpio.GPIOPinOut,
+	config Config,
+	now func() time.Time,
+	sleep func(time.Duration),
+) (*Device, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	d := &Device{
+		conn:   spiConn,
+		pinDC:  pinDC,
+		pinRST: pinRST,
+		config: config,
+		now:    now,
+		sleep:  sleep,
+	}
+	d.setLayout(config.Rotation)
+	// the content of the panel memory is unknown after reset
+	d.markAllChanged()
+	if err := d.initLCD(); err != nil {
+		return nil, err
+	}
 	return d, nil
 }
 
-func (dev *device) Update() int {
-	counter := 0
-	for seg := 0; seg < num_of_segments; seg++ {
-		if dev.isSegmentChanged[seg] {
-			dev.refreshSegment(seg)
-			dev.isSegmentChanged[seg] = false
-			counter++
+// SetRotation changes the orientation of the screen. The content of the buffer is
+// laid out again for the new orientation, so the picture on the panel does not change.
+func (dev *Device) SetRotation(rotation Rotation) error {
+	madctl, ok := memory_access_controls[rotation]
+	if !ok {
+		return errors.New("invalid rotation")
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.waitTransfer()
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	oldLayout := dev.screenLayout
+	oldFrame := dev.frame
+	oldMadctl := memory_access_controls[dev.rotation]
+	dev.setLayout(rotation)
+	for y := 0; y < oldLayout.height; y++ {
+		for x := 0; x < oldLayout.width; x++ {
+			i := oldLayout.offset(x, y)
+			col, row := dev.toPanel(x, y, oldMadctl)
+			j := dev.offset(dev.fromPanel(col, row, madctl))
+			dev.segments[j] = oldFrame.segments[i]
+			dev.segments[j+1] = oldFrame.segments[i+1]
 		}
 	}
-	return counter
+	if dev.front != nil {
+		copy(dev.front.segments, dev.segments)
+	}
+	dev.markAllChanged()
+	return dev.command(0x36, madctl)
+}
+
+func (dev *Device) Rotation() Rotation {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.rotation
+}
+
+func (dev *Device) setLayout(rotation Rotation) {
+	dev.rotation = rotation
+	l := screenLayout{
+		width:         dev.config.Width,
+		height:        dev.config.Height,
+		segmentWidth:  dev.config.SegmentWidth,
+		segmentHeight: dev.config.SegmentHeight,
+	}
+	if memory_access_controls[rotation]&row_col_exchange == 0 {
+		l.width, l.height = l.height, l.width
+		l.segmentWidth, l.segmentHeight = l.segmentHeight, l.segmentWidth
+	}
+	l.numXSeg = l.width / l.segmentWidth
+	l.numYSeg = l.height / l.segmentHeight
+	l.bytesPerSegment = l.segmentWidth * l.segmentHeight * 2
+	l.xAddress, l.yAddress = dev.addressOffsets(memory_access_controls[rotation])
+	dev.screenLayout = l
+	dev.frame = dev.newFrame()
+	dev.front = nil
+	if dev.config.DoubleBuffered {
+		dev.front = dev.newFrame()
+	}
+	dev.isSegmentTaken = make([]bool, l.numXSeg*l.numYSeg)
+	dev.streamBuf = make([]byte, 0, dev.config.SPIChunkSize)
 }
 
-func (dev *device) Pixel(x, y int, color colors.Color) {
+// addressOffsets returns the offsets of the panel in the memory along the screen
+// axes. A mirrored axis counts from the other end of the memory.
+func (dev *Device) addressOffsets(madctl byte) (x, y int) {
+	memoryColumns, memoryRows := dev.config.memorySize()
+	col := dev.config.ColumnOffset
+	row := dev.config.RowOffset
+	colMirror, rowMirror := column_address_order, row_address_order
+	if madctl&row_col_exchange != 0 {
+		colMirror, rowMirror = rowMirror, colMirror
+	}
+	if madctl&colMirror != 0 {
+		col = memoryColumns - dev.config.Height - col
+	}
+	if madctl&rowMirror != 0 {
+		row = memoryRows - dev.config.Width - row
+	}
+	if madctl&row_col_exchange != 0 {
+		return row, col
+	}
+	return col, row
+}
+
+func (dev *Device) markAllChanged() {
+	if dev.config.DirtyTracking == DIRTY_RECTANGLES {
+		dev.rectangles = append(dev.rectangles[:0], rectangle{0, 0, dev.width - 1, dev.height - 1})
+		return
+	}
+	for seg := range dev.isSegmentChanged {
+		dev.isSegmentChanged[seg] = true
+	}
+}
+
+func (dev *Device) Pixel(x, y int, color colors.Color) {
 	c, _ := colors.ToRGB565(color)
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
 	dev.pixel(x, y, c)
 }
 
-func (dev *device) ScreenWidth() int {
-	return lcd_width
+func (dev *Device) ScreenWidth() int {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.width
 }
 
-func (dev *device) ScreenHeight() int {
-	return lcd_height
+func (dev *Device) ScreenHeight() int {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.height
 }
 
-func (dev *device) writeCommand(cmd byte) {
+func (dev *Device) writeCommand(cmd byte) error {
 	dev.pinDC.Out(gpio.Low)
-	dev.conn.Tx([]byte{cmd}, nil)
+	dev.cmdBuf[0] = cmd
+	return dev.tx(dev.cmdBuf[:], nil)
 }
 
-func (dev *device) WriteDataByte(data byte) (byte, error) {
+func (dev *Device) WriteDataByte(data byte) (byte, error) {
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
 	dev.pinDC.Out(gpio.High)
-	res := make([]byte, 1)
-	err := dev.conn.Tx([]byte{data}, res)
-	return res[0], err
+	dev.dataBuf = append(dev.dataBuf[:0], data)
+	dev.resBuf = append(dev.resBuf[:0], 0)
+	err := dev.tx(dev.dataBuf, dev.resBuf)
+	return dev.resBuf[0], err
 }
 
-func (dev *device) refreshSegment(seg int) {
-	start := seg * bytes_per_segments
-	xseg := seg / num_x_seg
-	yseg := seg % num_x_seg
-	dev.setWindow(xseg*segment_width, yseg*segment_height, (xseg+1)*segment_width-1, (yseg+1)*segment_height-1)
+// command writes a command followed by all of its parameters in a single transfer.
+func (dev *Device) command(cmd byte, data ...byte) error {
+	if err := dev.writeCommand(cmd); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
 	dev.pinDC.Out(gpio.High)
-	dev.conn.Tx(dev.segments[start:start+bytes_per_segments], nil)
+	dev.dataBuf = append(dev.dataBuf[:0], data...)
+	return dev.tx(dev.dataBuf, nil)
 }
 
-func (dev *device) initLCD() {
+func (dev *Device) initLCD() error {
 	dev.reset()
 
-	dev.writeCommand(0x11) //Sleep out
-
-	dev.writeCommand(0xCF)
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0xC1)
-	dev.WriteDataByte(0x30)
-	dev.writeCommand(0xED)
-	dev.WriteDataByte(0x64)
-	dev.WriteDataByte(0x03)
-	dev.WriteDataByte(0x12)
-	dev.WriteDataByte(0x81)
-	dev.writeCommand(0xE8)
-	dev.WriteDataByte(0x85)
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0x79)
-	dev.writeCommand(0xCB)
-	dev.WriteDataByte(0x39)
-	dev.WriteDataByte(0x2C)
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0x34)
-	dev.WriteDataByte(0x02)
-	dev.writeCommand(0xF7)
-	dev.WriteDataByte(0x20)
-	dev.writeCommand(0xEA)
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0x00)
-	dev.writeCommand(0xC0)  //Power control
-	dev.WriteDataByte(0x1D) //VRH[5:0]
-	dev.writeCommand(0xC1)  //Power control
-	dev.WriteDataByte(0x12) //SAP[2:0];BT[3:0]
-	dev.writeCommand(0xC5)  //VCM control
-	dev.WriteDataByte(0x33)
-	dev.WriteDataByte(0x3F)
-	dev.writeCommand(0xC7) //VCM control
-	dev.WriteDataByte(0x92)
-	dev.writeCommand(0x3A) // Memory Access Control
-	dev.WriteDataByte(0x55)
-	dev.writeCommand(0x36) // Memory Access Control
-	dev.WriteDataByte(memeory_access_control)
-	dev.writeCommand(0xB1)
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0x12)
-	dev.writeCommand(0xB6) // Display Function Control
-	dev.WriteDataByte(0x0A)
-	dev.WriteDataByte(0xA2)
-
-	dev.writeCommand(0x44)
-	dev.WriteDataByte(0x02)
-
-	dev.writeCommand(0xF2) // 3Gamma Function Disable
-	dev.WriteDataByte(0x00)
-	dev.writeCommand(0x26) //Gamma curve selected
-	dev.WriteDataByte(0x01)
-	dev.writeCommand(0xE0) //Set Gamma
-	dev.WriteDataByte(0x0F)
-	dev.WriteDataByte(0x22)
-	dev.WriteDataByte(0x1C)
-	dev.WriteDataByte(0x1B)
-	dev.WriteDataByte(0x08)
-	dev.WriteDataByte(0x0F)
-	dev.WriteDataByte(0x48)
-	dev.WriteDataByte(0xB8)
-	dev.WriteDataByte(0x34)
-	dev.WriteDataByte(0x05)
-	dev.WriteDataByte(0x0C)
-	dev.WriteDataByte(0x09)
-	dev.WriteDataByte(0x0F)
-	dev.WriteDataByte(0x07)
-	dev.WriteDataByte(0x00)
-	dev.writeCommand(0xE1) //Set Gamma
-	dev.WriteDataByte(0x00)
-	dev.WriteDataByte(0x23)
-	dev.WriteDataByte(0x24)
-	dev.WriteDataByte(0x07)
-	dev.WriteDataByte(0x10)
-	dev.WriteDataByte(0x07)
-	dev.WriteDataByte(0x38)
-	dev.WriteDataByte(0x47)
-	dev.WriteDataByte(0x4B)
-	dev.WriteDataByte(0x0A)
-	dev.WriteDataByte(0x13)
-	dev.WriteDataByte(0x06)
-	dev.WriteDataByte(0x30)
-	dev.WriteDataByte(0x38)
-	dev.WriteDataByte(0x0F)
-	dev.writeCommand(0x29) //Display on
-}
-
-func (dev *device) reset() {
-	const SLEEP_MS = 120
+	seq := dev.config.InitSequence
+	if seq == nil {
+		var err error
+		if seq, err = BuiltinInitSequence(dev.config); err != nil {
+			return err
+		}
+	}
+	if err := dev.runInitSequence(withPixelSetup(seq, memory_access_controls[dev.rotation])); err != nil {
+		return err
+	}
+	dev.sleepOutAt = dev.now()
+	return nil
+}
+
+func (dev *Device) reset() {
 	dev.pinRST.Out(gpio.High)
-	time.Sleep(time.Millisecond * SLEEP_MS)
+	dev.sleep(dev.config.ResetDelay)
 	dev.pinRST.Out(gpio.Low)
-	time.Sleep(time.Millisecond * SLEEP_MS)
+	dev.sleep(dev.config.ResetDelay)
 	dev.pinRST.Out(gpio.High)
-	time.Sleep(time.Millisecond * SLEEP_MS)
+	dev.sleep(dev.config.ResetDelay)
 }
 
-func (dev *device) pixel(x, y int, color colors.RGB565) {
-	if x < 0 || y < 0 || x >= lcd_width || y >= lcd_height {
+func (dev *Device) pixel(x, y int, color colors.RGB565) {
+	if x < 0 || y < 0 || x >= dev.width || y >= dev.height {
 		return
 	}
-	xseg := x / segment_width
-	yseg := y / segment_height
-	seg := xseg*num_x_seg + yseg
-	xoffs := x % segment_width
-	yoffs := y % segment_height
-	i := seg*bytes_per_segments + (yoffs*segment_width+xoffs)*2
-	rgbcolor := rgb565ToILI9341Color(color)
-	dev.segments[i] = byte(rgbcolor >> 8)
-	dev.segments[i+1] = byte(rgbcolor)
-	dev.isSegmentChanged[seg] = true
+	dev.stats.PixelsWritten++
+	x, y = dev.scrolled(x, y)
+	i := dev.offset(x, y)
+	rgbcolor := dev.toPanelColor(color)
+	hi, lo := byte(rgbcolor>>8), byte(rgbcolor)
+	if dev.segments[i] == hi && dev.segments[i+1] == lo {
+		return
+	}
+	dev.stats.PixelsChanged++
+	dev.segments[i] = hi
+	dev.segments[i+1] = lo
+	if dev.config.DirtyTracking == DIRTY_RECTANGLES {
+		dev.markRectangle(rectangle{x, y, x, y})
+		return
+	}
+	dev.isSegmentChanged[dev.segment(x, y)] = true
+}
+
+func (l screenLayout) segment(x, y int) int {
+	return (y/l.segmentHeight)*l.numXSeg + x/l.segmentWidth
+}
+
+// offset returns the index of a pixel in the segments buffer
+func (l screenLayout) offset(x, y int) int {
+	xoffs := x % l.segmentWidth
+	yoffs := y % l.segmentHeight
+	return l.segment(x, y)*l.bytesPerSegment + (yoffs*l.segmentWidth+xoffs)*2
+}
+
+// toPanel maps a screen coordinate to the native (portrait) panel coordinate
+// for a memory access control. MX and MY mirror the column and page addresses
+// and MV exchanges them.
+func (dev *Device) toPanel(x, y int, madctl byte) (col, row int) {
+	width, height := dev.config.Height, dev.config.Width
+	if madctl&row_col_exchange != 0 {
+		width, height = height, width
+	}
+	if madctl&column_address_order != 0 {
+		x = width - 1 - x
+	}
+	if madctl&row_address_order != 0 {
+		y = height - 1 - y
+	}
+	if madctl&row_col_exchange != 0 {
+		return y, x
+	}
+	return x, y
+}
+
+// fromPanel is the inverse of toPanel.
+func (dev *Device) fromPanel(col, row int, madctl byte) (x, y int) {
+	width, height := dev.config.Height, dev.config.Width
+	x, y = col, row
+	if madctl&row_col_exchange != 0 {
+		width, height = height, width
+		x, y = row, col
+	}
+	if madctl&column_address_order != 0 {
+		x = width - 1 - x
+	}
+	if madctl&row_address_order != 0 {
+		y = height - 1 - y
+	}
+	return x, y
+}
+
+func (dev *Device) toPanelColor(color colors.RGB565) colors.RGB565 {
+	if dev.config.ColorOrder == COLOR_ORDER_RGB {
+		return color
+	}
+	return rgb565ToILI9341Color(color)
 }
 
 func rgb565ToILI9341Color(color colors.RGB565) colors.RGB565 {
@@ -225,17 +393,20 @@ func rgb565ToILI9341Color(color colors.RGB565) colors.RGB565 {
 	return (red) | (green << 5) | (blue << 11)
 }
 
-func (dev *device) setWindow(xStart, yStart, xEnd, yEnd int) {
-	dev.writeCommand(0x2a)
-	dev.WriteDataByte(byte(xStart >> 8))
-	dev.WriteDataByte(byte(xStart & 0xff))
-	dev.WriteDataByte(byte(xEnd >> 8))
-	dev.WriteDataByte(byte(xEnd & 0xff))
+func (dev *Device) setWindow(xStart, yStart, xEnd, yEnd int) error {
+	if err := dev.setAddress(xStart, yStart, xEnd, yEnd); err != nil {
+		return err
+	}
+	return dev.writeCommand(0x2C)
+}
 
-	dev.writeCommand(0x2b)
-	dev.WriteDataByte(byte(yStart >> 8))
-	dev.WriteDataByte(byte(yStart & 0xff))
-	dev.WriteDataByte(byte(yEnd >> 8))
-	dev.WriteDataByte(byte(yEnd & 0xff))
-	dev.writeCommand(0x2C)
+// setAddress sets the column and page address range of a screen rectangle.
+func (dev *Device) setAddress(xStart, yStart, xEnd, yEnd int) error {
+	xStart, xEnd = xStart+dev.xAddress, xEnd+dev.xAddress
+	yStart, yEnd = yStart+dev.yAddress, yEnd+dev.yAddress
+	err := dev.command(0x2A, byte(xStart>>8), byte(xStart&0xff), byte(xEnd>>8), byte(xEnd&0xff))
+	if err != nil {
+		return err
+	}
+	return dev.command(0x2B, byte(yStart>>8), byte(yStart&0xff), byte(yEnd>>8), byte(yEnd&0xff))
 }
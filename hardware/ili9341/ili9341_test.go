@@ -0,0 +1,1141 @@
+package ili9341
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/devices/display"
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+	"github.com/marksaravi/devices-go/hardware/ili9341/ili9341test"
+	"github.com/marksaravi/devices-go/hardware/spi/spitest"
+	"github.com/marksaravi/fonts-go/fonts"
+)
+
+func testConfig(rotation Rotation) Config {
+	config := DefaultConfig()
+	config.ResetDelay = 0
+	config.SleepDelay = 0
+	config.Rotation = rotation
+	return config
+}
+
+func newTestDevice(t testing.TB, config Config) (*Device, *spitest.SPI) {
+	t.Helper()
+	dc := gpiotest.NewPin(gpio.Low)
+	rst := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	dev, err := NewILI9341WithConfig(conn, dc, rst, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Update()
+	dev.Wait()
+	conn.Reset()
+	return dev, conn
+}
+
+func newTestPanel(t testing.TB, config Config) (*Device, *ili9341test.Panel) {
+	t.Helper()
+	dc := gpiotest.NewPin(gpio.Low)
+	rst := gpiotest.NewPin(gpio.Low)
+	panel := ili9341test.NewPanel(dc)
+	dev, err := NewILI9341WithConfig(spitest.NewSPI(dc, panel), dc, rst, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, panel
+}
+
+// fakeClock only moves on the sleeps of the device.
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if d > 0 {
+		c.now = c.now.Add(d)
+		c.slept = append(c.slept, d)
+	}
+}
+
+func checkSleeps(t *testing.T, got, want []time.Duration) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("wanted sleeps %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sleep %d: wanted %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func checkCommands(t *testing.T, got, want []spitest.Command) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("wanted %d commands, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Cmd != want[i].Cmd || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("at %d, wanted %x %x, got %x %x", i, want[i].Cmd, want[i].Data, got[i].Cmd, got[i].Data)
+		}
+	}
+}
+
+func TestInitLCD(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.Low)
+	rst := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	if _, err := NewILI9341(conn, dc, rst, ROTATION_0); err != nil {
+		t.Fatal(err)
+	}
+
+	history := rst.History()
+	wantReset := []gpio.Level{gpio.High, gpio.Low, gpio.High}
+	if len(history) != len(wantReset) {
+		t.Fatalf("wanted reset sequence %v, got %v", wantReset, history)
+	}
+	for i := range wantReset {
+		if history[i] != wantReset[i] {
+			t.Errorf("wanted reset sequence %v, got %v", wantReset, history)
+		}
+	}
+
+	commands := conn.Commands()
+	if len(commands) != 21 {
+		t.Fatalf("wanted 21 init commands, got %d", len(commands))
+	}
+	if commands[0].Cmd != 0x11 {
+		t.Errorf("wanted sleep out first, got %x", commands[0].Cmd)
+	}
+	// the pixel format and the memory access control are set before Display On
+	checkCommands(t, commands[11:13], []spitest.Command{
+		{Cmd: 0x3A, Data: []byte{0x55}},
+		{Cmd: 0x36, Data: []byte{0xA0}},
+	})
+	if last := commands[len(commands)-1].Cmd; last != 0x29 {
+		t.Errorf("wanted display on last, got %x", last)
+	}
+}
+
+func TestInitSequence(t *testing.T) {
+	for _, controller := range []Controller{CONTROLLER_ILI9341, CONTROLLER_ILI9342, CONTROLLER_ST7789} {
+		config := testConfig(ROTATION_0)
+		config.Controller = controller
+		seq, err := BuiltinInitSequence(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq[0].Command != 0x11 || seq[len(seq)-1].Command != 0x29 {
+			t.Errorf("controller %d: wanted sleep out first and display on last", controller)
+		}
+		if !seq.has(0x3A) || !seq.has(0x36) || !seq.has(0x44) && controller != CONTROLLER_ST7789 {
+			t.Errorf("controller %d: wanted pixel format, memory access control and tear scanline in the sequence", controller)
+		}
+		dev, panel := newTestPanel(t, config)
+		if panel.Sleeping() || !panel.DisplayOn() || panel.MemoryAccessControl() != 0xA0 || panel.PixelFormat() != 0x55 {
+			t.Errorf("controller %d: wanted the panel ready after init", controller)
+		}
+		err = dev.SetGamma(Gamma{}, Gamma{})
+		if (err == nil) != controller.hasILI9341Tuning() {
+			t.Errorf("controller %d: wrong tuning support, got %v", controller, err)
+		}
+	}
+
+	config := testConfig(ROTATION_0)
+	config.InitSequence = InitSequence{
+		{Command: 0x11, Delay: 20 * time.Millisecond},
+		{Command: 0xB6, Params: []byte{0x0A, 0x82}},
+		{Command: 0x29},
+	}
+	config.ResetDelay = 10 * time.Millisecond
+	dc := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	clock := newFakeClock()
+	if _, err := newDevice(conn, dc, gpiotest.NewPin(gpio.Low), config, clock.Now, clock.Sleep); err != nil {
+		t.Fatal(err)
+	}
+	checkSleeps(t, clock.slept, []time.Duration{
+		config.ResetDelay,
+		config.ResetDelay,
+		config.ResetDelay,
+		20 * time.Millisecond,
+	})
+	checkCommands(t, conn.Commands(), []spitest.Command{
+		{Cmd: 0x11, Data: []byte{}},
+		{Cmd: 0xB6, Data: []byte{0x0A, 0x82}},
+		{Cmd: 0x3A, Data: []byte{0x55}},
+		{Cmd: 0x36, Data: []byte{0xA0}},
+		{Cmd: 0x29, Data: []byte{}},
+	})
+
+	// the pixel format of the sequence is kept
+	custom := withPixelSetup(InitSequence{{Command: 0x11}, {Command: 0x3A, Params: []byte{0x66}}, {Command: 0x29}}, 0xA0)
+	want := InitSequence{{Command: 0x11}, {Command: 0x3A, Params: []byte{0x66}}, {Command: 0x36, Params: []byte{0xA0}}, {Command: 0x29}}
+	if len(custom) != len(want) {
+		t.Fatalf("wanted %v, got %v", want, custom)
+	}
+	for i := range want {
+		if custom[i].Command != want[i].Command || !bytes.Equal(custom[i].Params, want[i].Params) {
+			t.Errorf("at %d, wanted %x %x, got %x %x", i, want[i].Command, want[i].Params, custom[i].Command, custom[i].Params)
+		}
+	}
+
+	conn.FailAfter(2, errors.New("tx failed"))
+	if _, err := NewILI9341WithConfig(conn, dc, gpiotest.NewPin(gpio.Low), config); err == nil || err.Error() != "init command b6: tx failed" {
+		t.Errorf("wanted the failed command in the error, got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dev, conn := newTestDevice(t, testConfig(ROTATION_0))
+	conn.Reset()
+
+	if n := dev.Update(); n != 0 {
+		t.Errorf("wanted 0 updated segments, got %d", n)
+	}
+	dev.Pixel(40, 30, colors.RED)
+	dev.Pixel(41, 30, colors.BLUE)
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted 1 updated segment, got %d", n)
+	}
+
+	segment := make([]byte, 32*24*2)
+	// red and blue are swapped for ILI9341
+	copy(segment[(6*32+8)*2:], []byte{0x00, 0x1F, 0xF8, 0x00})
+	checkCommands(t, conn.Commands(), []spitest.Command{
+		{Cmd: 0x2A, Data: []byte{0, 32, 0, 63}},
+		{Cmd: 0x2B, Data: []byte{0, 24, 0, 47}},
+		{Cmd: 0x2C, Data: segment},
+	})
+
+	conn.Reset()
+	if n := dev.Update(); n != 0 {
+		t.Errorf("wanted 0 updated segments, got %d", n)
+	}
+	if len(conn.Transactions()) != 0 {
+		t.Errorf("wanted no transactions, got %d", len(conn.Transactions()))
+	}
+}
+
+func TestUpdateOnPanel(t *testing.T) {
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		config := testConfig(ROTATION_0)
+		config.DirtyTracking = tracking
+		dev, panel := newTestPanel(t, config)
+		if panel.Sleeping() || !panel.DisplayOn() {
+			t.Fatalf("wanted the panel awake with display on after init")
+		}
+
+		want := display.NewImageDevice(320, 240)
+		drawTestScreen(display.NewRGBDisplay(dev))
+		drawTestScreen(display.NewRGBDisplay(want))
+		checkPanel(t, fmt.Sprintf("tracking %d", tracking), panel, want.Image())
+	}
+}
+
+// checkPanel compares the panel with a landscape picture, the panel is mounted
+// rotated in the default landscape orientation.
+func checkPanel(t *testing.T, name string, panel *ili9341test.Panel, want image.Image) {
+	t.Helper()
+	got := panel.Image()
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			w := toRGB565(want.At(x, y))
+			g := toRGB565(got.At(239-y, x))
+			if w != g {
+				t.Fatalf("%s: at %d,%d wanted %x, got %x", name, x, y, w, g)
+			}
+		}
+	}
+}
+
+func drawTestScreen(d display.RGBDisplay) {
+	d.SetBackgroundColor(colors.WHITE)
+	d.Clear()
+	d.SetColor(colors.RED)
+	d.FillRectangle(10, 20, 100, 60)
+	d.SetColor(colors.ROYALBLUE)
+	d.FillCircle(200, 150, 50)
+	d.SetColor(colors.GREEN)
+	d.Line(0, 239, 319, 0)
+	d.Update()
+	d.SetColor(colors.BLACK)
+	d.Pixel(5, 5)
+	d.Pixel(310, 230)
+	d.Line(150, 10, 170, 30)
+	d.Update()
+}
+
+func toRGB565(c color.Color) colors.RGB565 {
+	r, g, b, _ := c.RGBA()
+	return colors.RGB888ToRGB565(colors.RGB888((r>>8)<<16 | (g>>8)<<8 | b>>8))
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		rotation      Rotation
+		width, height int
+		col, row      int // where the screen pixel 1,2 is on the panel
+	}{
+		{ROTATION_0, 320, 240, 237, 1},
+		{ROTATION_90, 240, 320, 238, 317},
+		{ROTATION_180, 320, 240, 2, 318},
+		{ROTATION_270, 240, 320, 1, 2},
+		{ROTATION_0_MIRRORED, 320, 240, 237, 318},
+		{ROTATION_90_MIRRORED, 240, 320, 1, 317},
+		{ROTATION_180_MIRRORED, 320, 240, 2, 1},
+		{ROTATION_270_MIRRORED, 240, 320, 238, 2},
+	}
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	for _, test := range tests {
+		dev, panel := newTestPanel(t, testConfig(test.rotation))
+		if dev.ScreenWidth() != test.width || dev.ScreenHeight() != test.height {
+			t.Errorf("rotation %d: wanted %dx%d, got %dx%d", test.rotation, test.width, test.height, dev.ScreenWidth(), dev.ScreenHeight())
+		}
+		dev.Pixel(1, 2, colors.RED)
+		dev.Pixel(test.width-1, test.height-1, colors.BLUE)
+		dev.Update()
+		if got := panel.Image().At(test.col, test.row); got != red {
+			t.Errorf("rotation %d: wanted red at %d,%d, got %v", test.rotation, test.col, test.row, got)
+		}
+	}
+
+	if _, err := NewILI9341(spitest.NewSPI(nil, nil), gpiotest.NewPin(gpio.Low), gpiotest.NewPin(gpio.Low), Rotation(8)); err == nil {
+		t.Errorf("wanted error for invalid rotation")
+	}
+}
+
+func TestSetRotation(t *testing.T) {
+	dev, panel := newTestPanel(t, testConfig(ROTATION_0))
+	drawTestScreen(display.NewRGBDisplay(dev))
+	before := panel.Image()
+
+	if err := dev.SetRotation(ROTATION_90); err != nil {
+		t.Fatal(err)
+	}
+	if dev.ScreenWidth() != 240 || dev.ScreenHeight() != 320 {
+		t.Fatalf("wanted 240x320, got %dx%d", dev.ScreenWidth(), dev.ScreenHeight())
+	}
+	if n := dev.Update(); n != 100 {
+		t.Errorf("wanted all 100 segments updated, got %d", n)
+	}
+	after := panel.Image()
+	for row := 0; row < 320; row++ {
+		for col := 0; col < 240; col++ {
+			if before.At(col, row) != after.At(col, row) {
+				t.Fatalf("at %d,%d, the picture changed after rotation", col, row)
+			}
+		}
+	}
+
+	dev.Pixel(1, 2, colors.BLACK)
+	dev.Update()
+	if got := panel.Image().At(238, 317); got != (color.RGBA{A: 0xFF}) {
+		t.Errorf("wanted black at 238,317, got %v", got)
+	}
+	if err := dev.SetRotation(Rotation(-1)); err == nil {
+		t.Errorf("wanted error for invalid rotation")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	invalid := []func(c *Config){
+		func(c *Config) { c.Width = 0 },
+		func(c *Config) { c.Height = -240 },
+		func(c *Config) { c.SegmentWidth = 0 },
+		func(c *Config) { c.SegmentHeight = 25 },
+		func(c *Config) { c.ColorOrder = 2 },
+		func(c *Config) { c.SPIChunkSize = 0 },
+		func(c *Config) { c.ResetDelay = -1 },
+		func(c *Config) { c.SleepDelay = -1 },
+		func(c *Config) { c.Tuning.FrameRate.ClocksPerLine = 0x20 },
+		func(c *Config) { c.Controller = 3 },
+		func(c *Config) { c.Rotation = 8 },
+		func(c *Config) { c.DirtyTracking = 2 },
+	}
+	for i, change := range invalid {
+		config := testConfig(ROTATION_0)
+		change(&config)
+		_, err := NewILI9341WithConfig(spitest.NewSPI(nil, nil), gpiotest.NewPin(gpio.Low), gpiotest.NewPin(gpio.Low), config)
+		if err == nil {
+			t.Errorf("at %d, wanted error for invalid config", i)
+		}
+	}
+}
+
+func TestSquarePanel(t *testing.T) {
+	config := testConfig(ROTATION_270)
+	config.Width = 240
+	config.Height = 240
+	config.SegmentWidth = 40
+	config.SegmentHeight = 30
+	config.ColorOrder = COLOR_ORDER_RGB
+	dev, panel := newTestPanel(t, config)
+	panel.BGRFilter = false
+	if dev.ScreenWidth() != 240 || dev.ScreenHeight() != 240 {
+		t.Fatalf("wanted 240x240, got %dx%d", dev.ScreenWidth(), dev.ScreenHeight())
+	}
+	if n := dev.Update(); n != 48 {
+		t.Errorf("wanted all 48 segments updated after init, got %d", n)
+	}
+	dev.Pixel(239, 239, colors.RED)
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted 1 updated segment, got %d", n)
+	}
+	if got := panel.Image().At(239, 239); got != (color.RGBA{R: 0xFF, A: 0xFF}) {
+		t.Errorf("wanted red at 239,239, got %v", got)
+	}
+}
+
+func TestSPIChunkSize(t *testing.T) {
+	config := testConfig(ROTATION_0)
+	config.SPIChunkSize = 500
+	dev, conn := newTestDevice(t, config)
+	dev.Pixel(0, 0, colors.RED)
+	conn.Reset()
+	dev.Update()
+	transactions := conn.Transactions()
+	sizes := []int{}
+	for _, t := range transactions[len(transactions)-4:] {
+		sizes = append(sizes, len(t.W))
+	}
+	want := []int{500, 500, 500, 36}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("wanted transfers of %v bytes, got %v", want, sizes)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errTx := errors.New("tx failed")
+	dc := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	conn.FailAfter(10, errTx)
+	if _, err := NewILI9341WithConfig(conn, dc, gpiotest.NewPin(gpio.Low), testConfig(ROTATION_0)); !errors.Is(err, errTx) {
+		t.Errorf("wanted init error, got %v", err)
+	}
+
+	dev, conn := newTestDevice(t, testConfig(ROTATION_0))
+	dev.Pixel(0, 0, colors.RED)
+	conn.Reset()
+	dev.Update()
+	txPerSegment := len(conn.Transactions())
+
+	dev.Pixel(0, 0, colors.BLUE)
+	dev.Pixel(100, 100, colors.RED)
+	dev.Pixel(300, 200, colors.RED)
+	// fail in the middle of the second segment
+	conn.FailAfter(txPerSegment+2, errTx)
+	n, err := dev.UpdateErr()
+	if n != 1 || !errors.Is(err, errTx) {
+		t.Fatalf("wanted 1 segment and error, got %d, %v", n, err)
+	}
+	if err.Error() != "segment 43: tx failed" {
+		t.Errorf("wanted the failed segment in the error, got %q", err)
+	}
+	conn.FailAfter(0, nil)
+	n, err = dev.UpdateErr()
+	if n != 2 || err != nil {
+		t.Errorf("wanted 2 segments without error, got %d, %v", n, err)
+	}
+	conn.FailAfter(0, errTx)
+	if err := dev.SetRotation(ROTATION_90); !errors.Is(err, errTx) {
+		t.Errorf("wanted rotation error, got %v", err)
+	}
+}
+
+func BenchmarkUpdateRow(b *testing.B) {
+	dev, conn := newTestDevice(b, testConfig(ROTATION_0))
+	transactions := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := colors.RED
+		if i%2 == 1 {
+			c = colors.BLUE
+		}
+		for x := 0; x < dev.ScreenWidth(); x += 32 {
+			dev.Pixel(x, 100, c)
+		}
+		conn.Reset()
+		dev.Update()
+		transactions += len(conn.Transactions())
+	}
+	b.ReportMetric(float64(transactions)/float64(b.N), "tx/op")
+}
+
+func BenchmarkUpdateFullScreen(b *testing.B) {
+	dev, conn := newTestDevice(b, testConfig(ROTATION_0))
+	transactions := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for seg := range dev.isSegmentChanged {
+			dev.isSegmentChanged[seg] = true
+		}
+		conn.Reset()
+		b.StartTimer()
+		dev.Update()
+		transactions += len(conn.Transactions())
+	}
+	b.ReportMetric(float64(transactions)/float64(b.N), "tx/op")
+}
+
+func TestMergeSegments(t *testing.T) {
+	dev, conn := newTestDevice(t, testConfig(ROTATION_0))
+	for _, seg := range [][2]int{{0, 0}, {1, 0}, {2, 0}, {0, 1}, {1, 1}, {2, 1}, {3, 1}, {5, 1}, {6, 1}, {9, 9}} {
+		dev.Pixel(seg[0]*32, seg[1]*24, colors.RED)
+	}
+	conn.Reset()
+	if n := dev.Update(); n != 10 {
+		t.Errorf("wanted 10 updated segments, got %d", n)
+	}
+	windows := [][2][]byte{}
+	for _, c := range conn.Commands() {
+		switch c.Cmd {
+		case 0x2A:
+			windows = append(windows, [2][]byte{c.Data, nil})
+		case 0x2B:
+			windows[len(windows)-1][1] = c.Data
+		}
+	}
+	want := [][2][]byte{
+		{{0, 0, 0, 95}, {0, 0, 0, 47}},
+		{{0, 96, 0, 127}, {0, 24, 0, 47}},
+		{{0, 160, 0, 223}, {0, 24, 0, 47}},
+		{{1, 32, 1, 63}, {0, 216, 0, 239}},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("wanted %d windows, got %d", len(want), len(windows))
+	}
+	for i := range want {
+		if !bytes.Equal(windows[i][0], want[i][0]) || !bytes.Equal(windows[i][1], want[i][1]) {
+			t.Errorf("at %d, wanted window %v, got %v", i, want[i], windows[i])
+		}
+	}
+	for _, c := range conn.Commands() {
+		if c.Cmd == 0x2C {
+			if len(c.Data) != 3*2*32*24*2 {
+				t.Errorf("wanted the first window in one memory write, got %d bytes", len(c.Data))
+			}
+			break
+		}
+	}
+}
+
+func TestDirtyRectangles(t *testing.T) {
+	config := testConfig(ROTATION_0)
+	config.DirtyTracking = DIRTY_RECTANGLES
+	dev, conn := newTestDevice(t, config)
+
+	dev.Pixel(10, 10, colors.RED)
+	dev.Pixel(11, 10, colors.RED)
+	dev.Pixel(10, 11, colors.RED)
+	dev.Pixel(200, 100, colors.RED)
+	conn.Reset()
+	if n := dev.Update(); n != 2 {
+		t.Errorf("wanted 2 updated rectangles, got %d", n)
+	}
+	checkCommands(t, conn.Commands(), []spitest.Command{
+		{Cmd: 0x2A, Data: []byte{0, 10, 0, 11}},
+		{Cmd: 0x2B, Data: []byte{0, 10, 0, 11}},
+		{Cmd: 0x2C, Data: []byte{0x00, 0x1F, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00}},
+		{Cmd: 0x2A, Data: []byte{0, 200, 0, 200}},
+		{Cmd: 0x2B, Data: []byte{0, 100, 0, 100}},
+		{Cmd: 0x2C, Data: []byte{0x00, 0x1F}},
+	})
+
+	for x := 0; x < 320; x += 10 {
+		dev.Pixel(x, x%240, colors.BLUE)
+	}
+	if len(dev.rectangles) > max_changed_rectangles {
+		t.Errorf("wanted at most %d rectangles, got %d", max_changed_rectangles, len(dev.rectangles))
+	}
+	dev.Update()
+	if len(dev.rectangles) != 0 {
+		t.Errorf("wanted no rectangles after update, got %d", len(dev.rectangles))
+	}
+}
+
+func benchmarkClockDigit(b *testing.B, tracking DirtyTracking) {
+	config := testConfig(ROTATION_0)
+	config.DirtyTracking = tracking
+	dev, conn := newTestDevice(b, config)
+	d := display.NewRGBDisplay(dev)
+	d.SetFont(fonts.FreeSerif24pt7b)
+	d.SetColor(colors.BLACK)
+	d.SetBackgroundColor(colors.WHITE)
+	d.Clear()
+	d.Update()
+	sent := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.MoveCursor(150, 100)
+		d.Write(fmt.Sprint(i % 10))
+		conn.Reset()
+		d.Update()
+		for _, t := range conn.Transactions() {
+			sent += len(t.W)
+		}
+	}
+	b.ReportMetric(float64(sent)/float64(b.N), "bytes/op")
+}
+
+func BenchmarkClockDigitSegments(b *testing.B) {
+	benchmarkClockDigit(b, DIRTY_SEGMENTS)
+}
+
+func BenchmarkClockDigitRectangles(b *testing.B) {
+	benchmarkClockDigit(b, DIRTY_RECTANGLES)
+}
+
+func TestContentAwareUpdate(t *testing.T) {
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		config := testConfig(ROTATION_0)
+		config.DirtyTracking = tracking
+		dev, conn := newTestDevice(t, config)
+		d := display.NewRGBDisplay(dev)
+		d.SetBackgroundColor(colors.WHITE)
+		d.Clear()
+		d.Update()
+		stats := dev.Stats()
+		if stats.PixelsWritten != 320*240 || stats.PixelsChanged != 320*240 {
+			t.Errorf("tracking %d: wanted all pixels written and changed, got %+v", tracking, stats)
+		}
+		if stats.BytesSent < 320*240*2 {
+			t.Errorf("tracking %d: wanted at least a full frame sent, got %d bytes", tracking, stats.BytesSent)
+		}
+
+		d.Clear()
+		d.SetColor(colors.RED)
+		d.Pixel(40, 30)
+		// the commands between the updates are not counted with the frame
+		dev.SetInversion(true)
+		dev.SetInversion(false)
+		conn.Reset()
+		if n := d.Update(); n != 1 {
+			t.Errorf("tracking %d: wanted 1 update, got %d", tracking, n)
+		}
+		sent := 0
+		for _, t := range conn.Transactions() {
+			sent += len(t.W)
+		}
+		want := FrameStats{PixelsWritten: 320*240 + 1, PixelsChanged: 1, BytesSent: sent}
+		if tracking == DIRTY_SEGMENTS {
+			want.SegmentsFlushed = 1
+		} else {
+			want.RectanglesFlushed = 1
+		}
+		if got := dev.Stats(); got != want {
+			t.Errorf("tracking %d: wanted %+v, got %+v", tracking, want, got)
+		}
+	}
+}
+
+func TestFillRect(t *testing.T) {
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		config := testConfig(ROTATION_0)
+		config.DirtyTracking = tracking
+		dev, _ := newTestDevice(t, config)
+		want, _ := newTestDevice(t, config)
+		draw := func(d *Device, x1, y1, x2, y2 int, c colors.Color) {
+			if d == dev {
+				d.FillRect(x1, y1, x2, y2, c)
+				return
+			}
+			for y := y1; y <= y2; y++ {
+				for x := x1; x <= x2; x++ {
+					d.Pixel(x, y, c)
+				}
+			}
+		}
+		for _, d := range []*Device{dev, want} {
+			draw(d, -10, -10, 400, 300, colors.WHITE)
+			draw(d, 30, 20, 70, 50, colors.RED)
+			draw(d, 31, 21, 69, 49, colors.RED)
+			draw(d, 300, 100, 300, 239, colors.BLUE)
+		}
+		if dev.stats != want.stats {
+			t.Errorf("tracking %d: wanted stats %+v, got %+v", tracking, want.stats, dev.stats)
+		}
+		if !bytes.Equal(dev.segments, want.segments) {
+			t.Errorf("tracking %d: segments differ", tracking)
+		}
+		for seg := range want.isSegmentChanged {
+			if dev.isSegmentChanged[seg] != want.isSegmentChanged[seg] {
+				t.Errorf("tracking %d: segment %d changed state differs", tracking, seg)
+			}
+		}
+		if n, m := dev.Update(), want.Update(); n != m {
+			t.Errorf("tracking %d: wanted %d updates, got %d", tracking, m, n)
+		}
+		if dev.lastStats.BytesSent > want.lastStats.BytesSent {
+			t.Errorf("tracking %d: wanted at most %d bytes, got %d", tracking, want.lastStats.BytesSent, dev.lastStats.BytesSent)
+		}
+	}
+}
+
+func benchmarkFillScreen(b *testing.B, fill func(dev *Device, c colors.Color)) {
+	dev, _ := newTestDevice(b, testConfig(ROTATION_0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := colors.RED
+		if i%2 == 1 {
+			c = colors.BLUE
+		}
+		fill(dev, c)
+	}
+}
+
+func BenchmarkFillScreenPixels(b *testing.B) {
+	benchmarkFillScreen(b, func(dev *Device, c colors.Color) {
+		for y := 0; y < dev.height; y++ {
+			for x := 0; x < dev.width; x++ {
+				dev.Pixel(x, y, c)
+			}
+		}
+	})
+}
+
+func BenchmarkFillScreenFillRect(b *testing.B) {
+	benchmarkFillScreen(b, func(dev *Device, c colors.Color) {
+		dev.FillRect(0, 0, dev.width-1, dev.height-1, c)
+	})
+}
+
+func newDoubleBufferedPanel(t *testing.T, tracking DirtyTracking) (*Device, *ili9341test.Panel, *spitest.SPI) {
+	t.Helper()
+	config := testConfig(ROTATION_0)
+	config.DirtyTracking = tracking
+	config.DoubleBuffered = true
+	dc := gpiotest.NewPin(gpio.Low)
+	panel := ili9341test.NewPanel(dc)
+	conn := spitest.NewSPI(dc, panel)
+	dev, err := NewILI9341WithConfig(conn, dc, gpiotest.NewPin(gpio.Low), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetLatency(time.Millisecond)
+	return dev, panel, conn
+}
+
+func drawFirstFrame(d display.RGBDisplay) {
+	d.SetBackgroundColor(colors.WHITE)
+	d.Clear()
+	d.SetColor(colors.RED)
+	d.FillRectangle(10, 20, 100, 60)
+}
+
+func drawSecondFrame(d display.RGBDisplay) {
+	d.SetColor(colors.BLUE)
+	d.FillCircle(200, 150, 50)
+	d.SetColor(colors.BLACK)
+	d.Line(0, 239, 319, 0)
+}
+
+func TestDoubleBuffered(t *testing.T) {
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		name := fmt.Sprintf("tracking %d", tracking)
+		dev, panel, _ := newDoubleBufferedPanel(t, tracking)
+		want := display.NewImageDevice(320, 240)
+		drawFirstFrame(display.NewRGBDisplay(dev))
+		drawFirstFrame(display.NewRGBDisplay(want))
+		first := want.Image()
+		pixels := want.Update()
+
+		if _, err := dev.UpdateErr(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		select {
+		case <-dev.Done():
+			t.Fatalf("%s: wanted Update to return before the transfer is complete", name)
+		default:
+		}
+		// drawing during the transfer must not reach the panel with this frame
+		drawSecondFrame(display.NewRGBDisplay(dev))
+		drawSecondFrame(display.NewRGBDisplay(want))
+		if err := dev.Wait(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkPanel(t, name+" first frame", panel, first)
+		if stats := dev.Stats(); stats.BytesSent == 0 || stats.PixelsWritten != pixels {
+			t.Errorf("%s: wanted the stats of the first frame, got %+v", name, stats)
+		}
+
+		dev.Update()
+		if err := dev.Wait(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkPanel(t, name+" second frame", panel, want.Image())
+		if n := dev.Update(); n != 0 {
+			t.Errorf("%s: wanted nothing to update, got %d", name, n)
+		}
+	}
+}
+
+func TestUpdateContext(t *testing.T) {
+	dev, conn := newTestDevice(t, testConfig(ROTATION_0))
+	dev.Pixel(0, 0, colors.RED)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := dev.UpdateContext(ctx); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("wanted cancelled update, got %d, %v", n, err)
+	}
+	if len(conn.Transactions()) != 0 {
+		t.Errorf("wanted no transactions after cancel, got %d", len(conn.Transactions()))
+	}
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted the segment to stay changed after cancel, got %d", n)
+	}
+
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		name := fmt.Sprintf("tracking %d", tracking)
+		dev, panel, _ := newDoubleBufferedPanel(t, tracking)
+		want := display.NewImageDevice(320, 240)
+		drawFirstFrame(display.NewRGBDisplay(dev))
+		drawFirstFrame(display.NewRGBDisplay(want))
+		ctx, cancel := context.WithCancel(context.Background())
+		dev.UpdateContext(ctx)
+		cancel()
+		if err := dev.Wait(); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: wanted cancelled transfer, got %v", name, err)
+		}
+		// the parts which are not sent are sent with the next frame
+		if _, err := dev.UpdateErr(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := dev.Wait(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkPanel(t, name, panel, want.Image())
+	}
+}
+
+func TestScroll(t *testing.T) {
+	for _, tracking := range []DirtyTracking{DIRTY_SEGMENTS, DIRTY_RECTANGLES} {
+		name := fmt.Sprintf("tracking %d", tracking)
+		config := testConfig(ROTATION_0)
+		config.DirtyTracking = tracking
+		dev, panel := newTestPanel(t, config)
+		drawn := display.NewImageDevice(320, 240)
+		drawTestScreen(display.NewRGBDisplay(dev))
+		drawTestScreen(display.NewRGBDisplay(drawn))
+
+		if err := dev.SetScrollArea(16, 32); err != nil {
+			t.Fatal(err)
+		}
+		if err := dev.Scroll(-222); err != nil || dev.ScrollOffset() != 50 {
+			t.Fatalf("%s: wanted offset 50, got %d, %v", name, dev.ScrollOffset(), err)
+		}
+		if n := dev.Update(); n != 0 {
+			t.Errorf("%s: wanted nothing to update after scroll, got %d", name, n)
+		}
+		// the panel lines are the columns of the screen with ROTATION_0
+		want := display.NewImageDevice(320, 240)
+		before := drawn.Image()
+		for y := 0; y < 240; y++ {
+			for x := 0; x < 320; x++ {
+				src := x
+				if x >= 16 && x < 288 {
+					src = 16 + (x-16+50)%272
+				}
+				r, g, b, _ := before.At(src, y).RGBA()
+				want.Pixel(x, y, colors.RGB888((r>>8)<<16|(g>>8)<<8|b>>8))
+			}
+		}
+		checkPanel(t, name+" scrolled", panel, want.Image())
+
+		// drawing after scroll uses the screen coordinates
+		for _, d := range []display.RGBDisplay{display.NewRGBDisplay(dev), display.NewRGBDisplay(want)} {
+			d.SetColor(colors.ORANGE)
+			d.FillRectangle(200, 100, 300, 140)
+			d.SetColor(colors.NAVY)
+			d.Line(0, 0, 319, 239)
+			d.Update()
+		}
+		checkPanel(t, name+" drawn", panel, want.Image())
+	}
+	// FillRect is split where the scrolling area wraps
+	for _, rotation := range []Rotation{ROTATION_90, ROTATION_180_MIRRORED} {
+		filled, _ := newTestDevice(t, testConfig(rotation))
+		drawn, _ := newTestDevice(t, testConfig(rotation))
+		for _, dev := range []*Device{filled, drawn} {
+			dev.SetScrollArea(10, 20)
+			dev.Scroll(100)
+		}
+		filled.FillRect(5, 5, 200, 300, colors.RED)
+		for y := 5; y <= 300; y++ {
+			for x := 5; x <= 200; x++ {
+				drawn.Pixel(x, y, colors.RED)
+			}
+		}
+		if !bytes.Equal(filled.segments, drawn.segments) {
+			t.Errorf("rotation %d: wanted FillRect to match Pixel when scrolled", rotation)
+		}
+	}
+
+	dev, _ := newTestDevice(t, testConfig(ROTATION_0))
+	if err := dev.SetScrollArea(200, 120); err == nil {
+		t.Errorf("wanted error for a scroll area without lines")
+	}
+}
+
+func TestPower(t *testing.T) {
+	dev, panel := newTestPanel(t, testConfig(ROTATION_0))
+	drawn := display.NewImageDevice(320, 240)
+	for _, d := range []display.RGBDisplay{display.NewRGBDisplay(dev), display.NewRGBDisplay(drawn)} {
+		d.SetBackgroundColor(colors.WHITE)
+		d.Clear()
+		d.SetColor(colors.ORANGE)
+		d.FillRectangle(10, 10, 100, 100)
+		d.Update()
+	}
+	black := display.NewImageDevice(320, 240)
+	display.NewRGBDisplay(black).Clear()
+
+	steps := []struct {
+		name   string
+		change func() error
+		want   func(c color.RGBA) color.RGBA
+	}{
+		{"display off", dev.DisplayOff, nil},
+		{"display on", dev.DisplayOn, func(c color.RGBA) color.RGBA { return c }},
+		{"sleep in", dev.SleepIn, nil},
+		{"sleep out", dev.SleepOut, func(c color.RGBA) color.RGBA { return c }},
+		{"inversion", func() error { return dev.SetInversion(true) }, func(c color.RGBA) color.RGBA {
+			return color.RGBA{R: ^c.R, G: ^c.G, B: ^c.B, A: 0xFF}
+		}},
+		{"idle", func() error { return dev.SetIdleMode(true) }, func(c color.RGBA) color.RGBA {
+			return color.RGBA{R: ^(c.R >> 7 * 0xFF), G: ^(c.G >> 7 * 0xFF), B: ^(c.B >> 7 * 0xFF), A: 0xFF}
+		}},
+		{"normal", func() error {
+			if err := dev.SetInversion(false); err != nil {
+				return err
+			}
+			return dev.SetIdleMode(false)
+		}, func(c color.RGBA) color.RGBA { return c }},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if step.want == nil {
+			checkPanel(t, step.name, panel, black.Image())
+			continue
+		}
+		want := display.NewImageDevice(320, 240)
+		src := drawn.Image()
+		for y := 0; y < 240; y++ {
+			for x := 0; x < 320; x++ {
+				c := step.want(color.RGBAModel.Convert(src.At(x, y)).(color.RGBA))
+				want.Pixel(x, y, colors.RGB888(uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B)))
+			}
+		}
+		checkPanel(t, step.name, panel, want.Image())
+	}
+}
+
+func TestBacklight(t *testing.T) {
+	dev, _ := newTestDevice(t, testConfig(ROTATION_0))
+	if err := dev.SetBrightness(1); err == nil {
+		t.Errorf("wanted error without backlight pin")
+	}
+	pwm := gpiotest.NewPWMPin()
+	dev.SetBacklightPWM(pwm)
+	if err := dev.SetBrightness(0.25); err != nil || pwm.Duty() != 0.25 {
+		t.Errorf("wanted duty 0.25, got %v, %v", pwm.Duty(), err)
+	}
+	if err := dev.SetBrightness(1.5); err == nil {
+		t.Errorf("wanted error for invalid brightness")
+	}
+	pin := gpiotest.NewPin(gpio.Low)
+	dev.SetBacklightPin(pin)
+	dev.SetBrightness(0.1)
+	dev.SetBrightness(0)
+	if got := pin.History(); len(got) != 2 || got[0] != gpio.High || got[1] != gpio.Low {
+		t.Errorf("wanted the backlight switched on and off, got %v", got)
+	}
+}
+
+func TestSleepDelay(t *testing.T) {
+	config := testConfig(ROTATION_0)
+	config.SleepDelay = 30 * time.Millisecond
+	dc := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	clock := newFakeClock()
+	dev, err := newDevice(conn, dc, gpiotest.NewPin(gpio.Low), config, clock.Now, clock.Sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Reset()
+	clock.slept = nil
+	dev.SleepIn()
+	dev.SleepOut()
+	dev.SleepIn()
+	dev.SleepOut()
+	checkSleeps(t, clock.slept, []time.Duration{
+		// the delay is counted from the end of the init sequence
+		config.SleepDelay,
+		sleep_command_delay,
+		sleep_command_delay,
+		config.SleepDelay - sleep_command_delay,
+		sleep_command_delay,
+		sleep_command_delay,
+	})
+	checkCommands(t, conn.Commands(), []spitest.Command{{Cmd: 0x10, Data: []byte{}}, {Cmd: 0x11, Data: []byte{}}, {Cmd: 0x10, Data: []byte{}}, {Cmd: 0x11, Data: []byte{}}})
+}
+
+func TestReadBack(t *testing.T) {
+	config := testConfig(ROTATION_90)
+	config.SPIChunkSize = 100
+	dev, panel := newTestPanel(t, config)
+	panel.ID = [3]byte{0x54, 0x80, 0x66}
+	if id, err := dev.ReadDisplayID(); err != nil || id != (DisplayID{0x54, 0x80, 0x66}) {
+		t.Errorf("wanted display id 54 80 66, got %x, %v", id, err)
+	}
+	if model, err := dev.ReadModel(); err != nil || model != ILI9341_MODEL {
+		t.Errorf("wanted model 9341, got %x, %v", model, err)
+	}
+
+	status, err := dev.ReadStatus()
+	want := DisplayStatus{
+		BoosterOn:           true,
+		MemoryAccessControl: memory_access_controls[ROTATION_90],
+		PixelFormat:         5,
+		SleepOut:            true,
+		NormalMode:          true,
+		DisplayOn:           true,
+	}
+	if err != nil || status != want {
+		t.Errorf("wanted status %+v, got %+v, %v", want, status, err)
+	}
+	dev.SetIdleMode(true)
+	dev.DisplayOff()
+	if mode, err := dev.ReadPowerMode(); err != nil || mode != (PowerMode{BoosterOn: true, IdleMode: true, SleepOut: true, NormalMode: true}) {
+		t.Errorf("wanted idle mode with display off, got %+v, %v", mode, err)
+	}
+
+	drawn := display.NewImageDevice(240, 320)
+	for _, d := range []display.RGBDisplay{display.NewRGBDisplay(dev), display.NewRGBDisplay(drawn)} {
+		d.SetBackgroundColor(colors.WHITE)
+		d.Clear()
+		d.SetColor(colors.ORANGE)
+		d.FillCircle(120, 160, 70)
+		d.SetColor(colors.NAVY)
+		d.Line(0, 0, 239, 319)
+		d.Update()
+	}
+	pixels := make([]colors.RGB565, 50*60)
+	if err := dev.ReadGRAM(100, 130, 50, 60, pixels); err != nil {
+		t.Fatal(err)
+	}
+	img := drawn.Image()
+	for i, got := range pixels {
+		x, y := 100+i%50, 130+i/50
+		if w := toRGB565(img.At(x, y)); w != got {
+			t.Fatalf("at %d,%d wanted %x, got %x", x, y, w, got)
+		}
+	}
+	if err := dev.ReadGRAM(200, 0, 50, 1, pixels); err == nil {
+		t.Errorf("wanted error for an area outside the screen")
+	}
+	if err := dev.ReadGRAM(0, 0, 10, 10, pixels[:99]); err == nil {
+		t.Errorf("wanted error for a small buffer")
+	}
+
+	// without MISO the reads are all zeros
+	unconnected, _ := newTestDevice(t, testConfig(ROTATION_0))
+	if model, err := unconnected.ReadModel(); err != nil || model != 0 {
+		t.Errorf("wanted model 0 without a panel, got %x, %v", model, err)
+	}
+}
+
+func TestTuning(t *testing.T) {
+	adafruit, err := PresetTuning(TUNING_ADAFRUIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig(ROTATION_0)
+	config.Tuning = adafruit
+	dev, panel := newTestPanel(t, config)
+	tests := []struct {
+		cmd  byte
+		want []byte
+	}{
+		{0xC0, []byte{0x23}},
+		{0xC1, []byte{0x10}},
+		{0xC5, []byte{0x3E, 0x28}},
+		{0xC7, []byte{0x86}},
+		{0xB1, []byte{0x00, 0x18}},
+		{0xE0, adafruit.PositiveGamma[:]},
+		{0xE1, adafruit.NegativeGamma[:]},
+	}
+	for _, test := range tests {
+		if got := panel.Params(test.cmd); !bytes.Equal(got, test.want) {
+			t.Errorf("init %x: wanted %x, got %x", test.cmd, test.want, got)
+		}
+	}
+
+	dev, conn := newTestDevice(t, testConfig(ROTATION_0))
+	if err := dev.SetVCOM(VCOM{High: 0x40, Low: 0x20, Offset: 0xB0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SetFrameRate(FrameRate{Divider: 1, ClocksPerLine: 0x1F}); err != nil {
+		t.Fatal(err)
+	}
+	gamma := Gamma{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	if err := dev.SetGamma(gamma, gamma); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SetPowerControl(PowerControl{GVDD: 0x21, StepUp: 0x11}); err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, conn.Commands(), []spitest.Command{
+		{Cmd: 0xC5, Data: []byte{0x40, 0x20}},
+		{Cmd: 0xC7, Data: []byte{0xB0}},
+		{Cmd: 0xB1, Data: []byte{0x01, 0x1F}},
+		{Cmd: 0xE0, Data: gamma[:]},
+		{Cmd: 0xE1, Data: gamma[:]},
+		{Cmd: 0xC0, Data: []byte{0x21}},
+		{Cmd: 0xC1, Data: []byte{0x11}},
+	})
+	if tuning := dev.Tuning(); tuning.VCOM.Offset != 0xB0 || tuning.PositiveGamma != gamma {
+		t.Errorf("wanted the changed tuning, got %+v", tuning)
+	}
+
+	conn.Reset()
+	if err := dev.SetFrameRate(FrameRate{ClocksPerLine: 0x0F}); err == nil {
+		t.Errorf("wanted error for invalid frame rate")
+	}
+	if err := dev.SetVCOM(VCOM{High: 0x80}); err == nil {
+		t.Errorf("wanted error for invalid vcom")
+	}
+	if len(conn.Transactions()) != 0 {
+		t.Errorf("wanted nothing sent for invalid tuning")
+	}
+	if _, err := PresetTuning(TuningPreset(3)); err == nil {
+		t.Errorf("wanted error for invalid preset")
+	}
+	lowPower, _ := PresetTuning(TUNING_LOW_POWER)
+	if hz := lowPower.FrameRate.Hz(); hz < 30 || hz > 31 {
+		t.Errorf("wanted about 30Hz for low power, got %.1f", hz)
+	}
+}
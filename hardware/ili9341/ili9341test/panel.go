@@ -0,0 +1,475 @@
+// Package ili9341test provides a virtual ILI9341 panel that interprets the
+// command stream sent by a driver and reconstructs the picture on the glass.
+package ili9341test
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+const (
+	GRAM_WIDTH  int = 240
+	GRAM_HEIGHT int = 320
+)
+
+const (
+	cmd_software_reset     byte   = 0x01
+	cmd_read_id            byte   = 0x04
+	cmd_read_status        byte   = 0x09
+	cmd_read_power_mode    byte   = 0x0A
+	cmd_sleep_in           byte   = 0x10
+	cmd_sleep_out          byte   = 0x11
+	cmd_inversion_off      byte   = 0x20
+	cmd_inversion_on       byte   = 0x21
+	cmd_display_off        byte   = 0x28
+	cmd_display_on         byte   = 0x29
+	cmd_column_address_set byte   = 0x2A
+	cmd_page_address_set   byte   = 0x2B
+	cmd_memory_write       byte   = 0x2C
+	cmd_memory_read        byte   = 0x2E
+	cmd_scroll_definition  byte   = 0x33
+	cmd_memory_access_ctrl byte   = 0x36
+	cmd_scroll_start       byte   = 0x37
+	cmd_idle_off           byte   = 0x38
+	cmd_idle_on            byte   = 0x39
+	cmd_memory_read_next   byte   = 0x3E
+	cmd_read_id4           byte   = 0xD3
+	cmd_pixel_format_set   byte   = 0x3A
+	madctl_my              byte   = 0x80
+	madctl_mx              byte   = 0x40
+	madctl_mv              byte   = 0x20
+	madctl_bgr             byte   = 0x08
+	pixel_format_16bits    byte   = 0x05
+	pixel_format_mask      byte   = 0x07
+	component_bits         uint   = 6
+	component_mask         uint32 = 0x3F
+)
+
+// Panel is a virtual ILI9341 connected to the SPI bus. It implements spi.SPI
+// and uses the data/command pin to tell commands from parameters.
+// It understands CASET, PASET, RAMWR, MADCTL, COLMOD, vertical scrolling, sleep,
+// display on/off, idle mode and inversion;
+// the parameters of any other command are kept and can be inspected with Params.
+// The ID, status, power mode and memory reads answer with a dummy byte followed by the data.
+type Panel struct {
+	// BGRFilter tells the colour filter of the glass is BGR, which is how the
+	// common ILI9341 modules are wired. Setting the MADCTL BGR bit swaps it back.
+	BGRFilter bool
+	// ID is the answer to Read Display Identification (0x04).
+	ID [3]byte
+	// ID4 is the answer to Read ID4 (0xD3), the IC model.
+	ID4 [3]byte
+
+	mu         sync.Mutex
+	dc         gpio.GPIOPinIn
+	gram       []uint32 // 18 bits per pixel, first component in the highest bits
+	cmd        byte
+	params     []byte
+	lastParams map[byte][]byte
+	madctl     byte
+	colmod     byte
+	xs, xe     int
+	ys, ye     int
+	cx, cy     int
+	pixel      []byte
+	response   []byte // the bytes of a read command still to be clocked out
+	scrollTop  int    // lines of the top fixed area
+	scrollArea int    // lines of the scrolling area
+	scrollLine int    // memory line shown on the first line of the scrolling area
+	scrolling  bool
+	sleeping   bool
+	displayOn  bool
+	idle       bool
+	inverted   bool
+}
+
+func NewPanel(dc gpio.GPIOPinIn) *Panel {
+	p := &Panel{
+		BGRFilter: true,
+		ID4:       [3]byte{0x00, 0x93, 0x41},
+		dc:        dc,
+		gram:      make([]uint32, GRAM_WIDTH*GRAM_HEIGHT),
+	}
+	p.reset()
+	return p
+}
+
+func (p *Panel) reset() {
+	p.cmd = 0
+	p.params = make([]byte, 0)
+	p.lastParams = make(map[byte][]byte)
+	p.madctl = 0
+	p.colmod = 0x66
+	p.xs, p.xe = 0, GRAM_WIDTH-1
+	p.ys, p.ye = 0, GRAM_HEIGHT-1
+	p.pixel = make([]byte, 0, 3)
+	p.response = make([]byte, 0, 4)
+	p.scrollTop, p.scrollArea, p.scrollLine = 0, GRAM_HEIGHT, 0
+	p.scrolling = false
+	p.sleeping = true
+	p.displayOn = false
+	p.idle = false
+	p.inverted = false
+}
+
+func (p *Panel) Tx(w, r []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range r {
+		r[i] = 0
+	}
+	if p.dc.Read() == gpio.Low {
+		for _, cmd := range w {
+			p.command(cmd)
+		}
+		return nil
+	}
+	if p.isRead() {
+		for i := range w {
+			b := p.readByte()
+			if i < len(r) {
+				r[i] = b
+			}
+		}
+		return nil
+	}
+	for _, data := range w {
+		p.data(data)
+	}
+	return nil
+}
+
+func (p *Panel) isRead() bool {
+	switch p.cmd {
+	case cmd_read_id, cmd_read_status, cmd_read_power_mode, cmd_read_id4, cmd_memory_read, cmd_memory_read_next:
+		return true
+	}
+	return false
+}
+
+// readByte clocks out the next byte of a read command.
+func (p *Panel) readByte() byte {
+	if len(p.response) == 0 && (p.cmd == cmd_memory_read || p.cmd == cmd_memory_read_next) {
+		value := uint32(0)
+		if col, row, ok := p.toGRAM(p.cx, p.cy); ok {
+			value = p.gram[row*GRAM_WIDTH+col]
+		}
+		p.response = append(p.response,
+			byte(value>>(2*component_bits)&component_mask)<<2,
+			byte(value>>component_bits&component_mask)<<2,
+			byte(value&component_mask)<<2,
+		)
+		p.advance()
+	}
+	if len(p.response) == 0 {
+		return 0
+	}
+	b := p.response[0]
+	p.response = p.response[1:]
+	return b
+}
+
+// status returns the 32 bits of Read Display Status (0x09).
+func (p *Panel) status() uint32 {
+	var status uint32
+	if !p.sleeping {
+		status |= 1<<31 | 1<<17
+	}
+	status |= uint32(p.madctl&0xFC) << 23
+	status |= uint32(p.colmod&pixel_format_mask) << 20
+	if p.idle {
+		status |= 1 << 19
+	}
+	status |= 1 << 16 // normal mode
+	if p.scrolling {
+		status |= 1 << 15
+	}
+	if p.inverted {
+		status |= 1 << 13
+	}
+	if p.displayOn {
+		status |= 1 << 10
+	}
+	return status
+}
+
+func (p *Panel) command(cmd byte) {
+	p.cmd = cmd
+	p.params = p.params[:0]
+	switch cmd {
+	case cmd_software_reset:
+		p.reset()
+	case cmd_sleep_in:
+		p.sleeping = true
+	case cmd_sleep_out:
+		p.sleeping = false
+	case cmd_display_off:
+		p.displayOn = false
+	case cmd_display_on:
+		p.displayOn = true
+	case cmd_idle_off:
+		p.idle = false
+	case cmd_idle_on:
+		p.idle = true
+	case cmd_inversion_off:
+		p.inverted = false
+	case cmd_inversion_on:
+		p.inverted = true
+	case cmd_memory_write:
+		p.cx, p.cy = p.xs, p.ys
+		p.pixel = p.pixel[:0]
+	}
+	// every read starts with a dummy byte
+	p.response = append(p.response[:0], 0)
+	switch cmd {
+	case cmd_read_id:
+		p.response = append(p.response, p.ID[:]...)
+	case cmd_read_id4:
+		p.response = append(p.response, p.ID4[:]...)
+	case cmd_read_status:
+		status := p.status()
+		p.response = append(p.response, byte(status>>24), byte(status>>16), byte(status>>8), byte(status))
+	case cmd_read_power_mode:
+		status := p.status()
+		// booster, idle, partial, sleep out, normal and display on
+		p.response = append(p.response, byte(status>>24)&0x80|byte(status>>13)&0x78|byte(status>>8)&0x04)
+	case cmd_memory_read:
+		p.cx, p.cy = p.xs, p.ys
+	}
+}
+
+func (p *Panel) data(data byte) {
+	if p.cmd == cmd_memory_write {
+		p.writePixelByte(data)
+		return
+	}
+	p.params = append(p.params, data)
+	p.lastParams[p.cmd] = append([]byte{}, p.params...)
+	switch p.cmd {
+	case cmd_column_address_set:
+		if len(p.params) == 4 {
+			p.xs, p.xe = addressRange(p.params)
+		}
+	case cmd_page_address_set:
+		if len(p.params) == 4 {
+			p.ys, p.ye = addressRange(p.params)
+		}
+	case cmd_scroll_definition:
+		if len(p.params) == 6 {
+			top, lines := int(p.params[0])<<8|int(p.params[1]), int(p.params[2])<<8|int(p.params[3])
+			bottom := int(p.params[4])<<8 | int(p.params[5])
+			// the definition is ignored unless it covers the whole memory
+			if top+lines+bottom == GRAM_HEIGHT && lines > 0 {
+				p.scrollTop, p.scrollArea = top, lines
+			}
+		}
+	case cmd_scroll_start:
+		if len(p.params) == 2 {
+			p.scrollLine = int(p.params[0])<<8 | int(p.params[1])
+			p.scrolling = true
+		}
+	case cmd_memory_access_ctrl:
+		p.madctl = data
+	case cmd_pixel_format_set:
+		p.colmod = data
+	}
+}
+
+func addressRange(params []byte) (int, int) {
+	return int(params[0])<<8 | int(params[1]), int(params[2])<<8 | int(params[3])
+}
+
+func (p *Panel) writePixelByte(data byte) {
+	p.pixel = append(p.pixel, data)
+	var value uint32
+	if p.colmod&pixel_format_mask == pixel_format_16bits {
+		if len(p.pixel) < 2 {
+			return
+		}
+		rgb := uint32(p.pixel[0])<<8 | uint32(p.pixel[1])
+		value = expand5(rgb>>11)<<12 | (rgb>>5&0x3F)<<6 | expand5(rgb)
+	} else {
+		if len(p.pixel) < 3 {
+			return
+		}
+		value = uint32(p.pixel[0]>>2)<<12 | uint32(p.pixel[1]>>2)<<6 | uint32(p.pixel[2]>>2)
+	}
+	p.pixel = p.pixel[:0]
+	if col, row, ok := p.toGRAM(p.cx, p.cy); ok {
+		p.gram[row*GRAM_WIDTH+col] = value
+	}
+	p.advance()
+}
+
+// advance moves the memory cursor to the next pixel of the window.
+func (p *Panel) advance() {
+	p.cx++
+	if p.cx > p.xe {
+		p.cx = p.xs
+		p.cy++
+		if p.cy > p.ye {
+			p.cy = p.ys
+		}
+	}
+}
+
+func expand5(c uint32) uint32 {
+	c &= 0x1F
+	return c<<1 | c>>4
+}
+
+// toGRAM maps a column/page address to the physical GRAM location following MADCTL.
+// MX and MY mirror the column and page addresses and MV exchanges them.
+func (p *Panel) toGRAM(x, y int) (col, row int, ok bool) {
+	width, height := GRAM_WIDTH, GRAM_HEIGHT
+	if p.madctl&madctl_mv != 0 {
+		width, height = height, width
+	}
+	if x < 0 || y < 0 || x >= width || y >= height {
+		return 0, 0, false
+	}
+	if p.madctl&madctl_mx != 0 {
+		x = width - 1 - x
+	}
+	if p.madctl&madctl_my != 0 {
+		y = height - 1 - y
+	}
+	if p.madctl&madctl_mv != 0 {
+		return y, x, true
+	}
+	return x, y, true
+}
+
+// GRAM returns the content of the frame memory as it would appear on the glass
+// when the display is on and not scrolled, regardless of the sleep and display states.
+func (p *Panel) GRAM() image.Image {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gramImage(false)
+}
+
+// Image returns the picture on the glass, which is black while the panel
+// is sleeping or the display is off. Vertical scrolling moves the lines of the
+// scrolling area, idle mode keeps the most significant bit of each component
+// and inversion inverts the colours.
+func (p *Panel) Image() image.Image {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sleeping || !p.displayOn {
+		img := image.NewRGBA(image.Rect(0, 0, GRAM_WIDTH, GRAM_HEIGHT))
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xFF
+		}
+		return img
+	}
+	return p.gramImage(true)
+}
+
+// gramImage converts the memory to an image, onGlass applies the scrolling,
+// idle mode and inversion.
+func (p *Panel) gramImage(onGlass bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, GRAM_WIDTH, GRAM_HEIGHT))
+	swapped := p.BGRFilter != (p.madctl&madctl_bgr != 0)
+	for row := 0; row < GRAM_HEIGHT; row++ {
+		line := row
+		if onGlass {
+			line = p.memoryLine(row)
+		}
+		for col := 0; col < GRAM_WIDTH; col++ {
+			value := p.gram[line*GRAM_WIDTH+col]
+			if onGlass {
+				value = p.glassValue(value)
+			}
+			first := to8bits(value >> (2 * component_bits))
+			green := to8bits(value >> component_bits)
+			last := to8bits(value)
+			c := color.RGBA{R: first, G: green, B: last, A: 0xFF}
+			if swapped {
+				c.R, c.B = last, first
+			}
+			img.SetRGBA(col, row, c)
+		}
+	}
+	return img
+}
+
+// glassValue applies the inversion and the idle mode to a memory value.
+func (p *Panel) glassValue(value uint32) uint32 {
+	if p.inverted {
+		value = ^value
+	}
+	if p.idle {
+		msb := uint32(1) << (component_bits - 1)
+		idle := uint32(0)
+		for shift := uint(0); shift < 3*component_bits; shift += component_bits {
+			if value&(msb<<shift) != 0 {
+				idle |= component_mask << shift
+			}
+		}
+		value = idle
+	}
+	return value
+}
+
+// memoryLine returns the line of the memory shown on a line of the glass.
+func (p *Panel) memoryLine(row int) int {
+	if row < p.scrollTop || row >= p.scrollTop+p.scrollArea {
+		return row
+	}
+	start := p.scrollLine
+	if start < p.scrollTop || start >= p.scrollTop+p.scrollArea {
+		start = p.scrollTop
+	}
+	return p.scrollTop + (row-p.scrollTop+start-p.scrollTop)%p.scrollArea
+}
+
+func to8bits(c uint32) uint8 {
+	c &= component_mask
+	return uint8(c<<2 | c>>4)
+}
+
+func (p *Panel) Idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idle
+}
+
+func (p *Panel) Inverted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inverted
+}
+
+func (p *Panel) MemoryAccessControl() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.madctl
+}
+
+func (p *Panel) PixelFormat() byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.colmod
+}
+
+func (p *Panel) Sleeping() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sleeping
+}
+
+func (p *Panel) DisplayOn() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.displayOn
+}
+
+// Params returns the last parameters received for a command.
+func (p *Panel) Params(cmd byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]byte{}, p.lastParams[cmd]...)
+}
@@ -0,0 +1,93 @@
+package ili9341test
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+)
+
+func send(p *Panel, dc *gpiotest.Pin, cmd byte, data ...byte) {
+	dc.Out(gpio.Low)
+	p.Tx([]byte{cmd}, nil)
+	if len(data) > 0 {
+		dc.Out(gpio.High)
+		p.Tx(data, nil)
+	}
+}
+
+func TestPanel(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.Low)
+	p := NewPanel(dc)
+	p.BGRFilter = false
+	if !p.Sleeping() || p.DisplayOn() {
+		t.Errorf("wanted the panel sleeping with display off after reset")
+	}
+	send(p, dc, cmd_sleep_out)
+	send(p, dc, cmd_display_on)
+	send(p, dc, cmd_pixel_format_set, 0x55)
+	send(p, dc, cmd_memory_access_ctrl, madctl_mx)
+	send(p, dc, cmd_column_address_set, 0, 0, 0, 1)
+	send(p, dc, cmd_page_address_set, 0, 10, 0, 11)
+	send(p, dc, cmd_memory_write, 0xF8, 0x00, 0x07, 0xE0, 0x00, 0x1F, 0xFF, 0xFF)
+
+	want := map[[2]int]color.RGBA{
+		{239, 10}: {R: 0xFF, A: 0xFF},
+		{238, 10}: {G: 0xFF, A: 0xFF},
+		{239, 11}: {B: 0xFF, A: 0xFF},
+		{238, 11}: {R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF},
+	}
+	img := p.Image()
+	for xy, c := range want {
+		if got := img.At(xy[0], xy[1]); got != c {
+			t.Errorf("at %v, wanted %v, got %v", xy, c, got)
+		}
+	}
+
+	send(p, dc, cmd_memory_access_ctrl, madctl_mv|madctl_bgr)
+	send(p, dc, cmd_pixel_format_set, 0x66)
+	send(p, dc, cmd_column_address_set, 0x01, 0x3F, 0x01, 0x3F)
+	send(p, dc, cmd_page_address_set, 0, 0, 0, 0)
+	send(p, dc, cmd_memory_write, 0xFC, 0x00, 0x00)
+	if got := p.GRAM().At(0, 319); got != (color.RGBA{B: 0xFF, A: 0xFF}) {
+		t.Errorf("wanted blue at 0,319, got %v", got)
+	}
+
+	send(p, dc, cmd_display_off)
+	if got := p.Image().At(0, 319); got != (color.RGBA{A: 0xFF}) {
+		t.Errorf("wanted black when display is off, got %v", got)
+	}
+	if got := p.Params(cmd_page_address_set); len(got) != 4 {
+		t.Errorf("wanted 4 parameters, got %x", got)
+	}
+}
+
+func TestPanelScroll(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.Low)
+	p := NewPanel(dc)
+	p.BGRFilter = false
+	send(p, dc, cmd_sleep_out)
+	send(p, dc, cmd_display_on)
+	send(p, dc, cmd_pixel_format_set, 0x55)
+	send(p, dc, cmd_column_address_set, 0, 0, 0, 0)
+	send(p, dc, cmd_page_address_set, 0, 10, 0, 10)
+	send(p, dc, cmd_memory_write, 0xF8, 0x00)
+	send(p, dc, cmd_page_address_set, 0, 50, 0, 50)
+	send(p, dc, cmd_memory_write, 0x00, 0x1F)
+
+	// 10 fixed lines at the top and 20 at the bottom
+	send(p, dc, cmd_scroll_definition, 0, 10, 0x01, 0x22, 0, 20)
+	send(p, dc, cmd_scroll_start, 0, 30)
+	red, blue := color.RGBA{R: 0xFF, A: 0xFF}, color.RGBA{B: 0xFF, A: 0xFF}
+	img := p.Image()
+	if got := img.At(0, 30); got != blue {
+		t.Errorf("wanted line 50 at 30, got %v", got)
+	}
+	if got := img.At(0, 10+290-20); got != red {
+		t.Errorf("wanted line 10 at 280, got %v", got)
+	}
+	if got := p.GRAM().At(0, 50); got != blue {
+		t.Errorf("wanted GRAM not scrolled, got %v", got)
+	}
+}
@@ -0,0 +1,167 @@
+package ili9341
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pixel_format_rgb565 is the 16 bits per pixel format of the frame buffer.
+const pixel_format_rgb565 byte = 0x55
+
+type Controller int
+
+// Controller selects the built-in init sequence of an ILI9341 compatible controller.
+// The ILI9342 memory is 320 columns by 240 lines, so Config.Width and Config.Height
+// are 240 and 320 for a landscape ILI9342 with ROTATION_270.
+const (
+	CONTROLLER_ILI9341 Controller = 0
+	CONTROLLER_ILI9342 Controller = 1
+	CONTROLLER_ST7789  Controller = 2
+)
+
+// InitStep is a command of an init sequence with its parameters and the time to
+// wait after it.
+type InitStep struct {
+	Command byte
+	Params  []byte
+	Delay   time.Duration
+}
+
+// InitSequence wakes the panel up, sets up the controller and turns the display on.
+// The driver inserts the pixel format 0x3A and the memory access control 0x36 of
+// Config.Rotation before the final Display On of a sequence without them.
+type InitSequence []InitStep
+
+// BuiltinInitSequence returns the init sequence of Config.Controller, built with
+// Config.Tuning and Config.SleepDelay. It can be changed and set as Config.InitSequence.
+func BuiltinInitSequence(config Config) (InitSequence, error) {
+	madctl, ok := memory_access_controls[config.Rotation]
+	if !ok {
+		return nil, errors.New("invalid rotation")
+	}
+	t := config.Tuning
+	switch config.Controller {
+	case CONTROLLER_ILI9341:
+		return InitSequence{
+			{Command: 0x11, Delay: config.SleepDelay}, //Sleep out
+			{Command: 0xCF, Params: []byte{0x00, 0xC1, 0x30}},
+			{Command: 0xED, Params: []byte{0x64, 0x03, 0x12, 0x81}},
+			{Command: 0xE8, Params: []byte{0x85, 0x00, 0x79}},
+			{Command: 0xCB, Params: []byte{0x39, 0x2C, 0x00, 0x34, 0x02}},
+			{Command: 0xF7, Params: []byte{0x20}},
+			{Command: 0xEA, Params: []byte{0x00, 0x00}},
+			{Command: 0xC0, Params: []byte{t.PowerControl.GVDD}},                            //Power control VRH[5:0]
+			{Command: 0xC1, Params: []byte{t.PowerControl.StepUp}},                          //Power control SAP[2:0];BT[3:0]
+			{Command: 0xC5, Params: []byte{t.VCOM.High, t.VCOM.Low}},                        //VCM control
+			{Command: 0xC7, Params: []byte{t.VCOM.Offset}},                                  //VCM control
+			{Command: 0x3A, Params: []byte{pixel_format_rgb565}},                            // Pixel Format Set
+			{Command: 0x36, Params: []byte{madctl}},                                         // Memory Access Control
+			{Command: 0xB1, Params: []byte{t.FrameRate.Divider, t.FrameRate.ClocksPerLine}}, // Frame Rate Control
+			{Command: 0xB6, Params: []byte{0x0A, 0xA2}},                                     // Display Function Control
+			{Command: 0x44, Params: []byte{0x02}},
+			{Command: 0xF2, Params: []byte{0x00}},       // 3Gamma Function Disable
+			{Command: 0x26, Params: []byte{0x01}},       //Gamma curve selected
+			{Command: 0xE0, Params: t.PositiveGamma[:]}, //Set Gamma
+			{Command: 0xE1, Params: t.NegativeGamma[:]}, //Set Gamma
+			{Command: 0x29}, //Display on
+		}, nil
+	case CONTROLLER_ILI9342:
+		return InitSequence{
+			{Command: 0x11, Delay: config.SleepDelay}, //Sleep out
+			{Command: 0xCF, Params: []byte{0x00, 0xC1, 0x30}},
+			{Command: 0xED, Params: []byte{0x64, 0x03, 0x12, 0x81}},
+			{Command: 0xE8, Params: []byte{0x85, 0x00, 0x78}},
+			{Command: 0xCB, Params: []byte{0x39, 0x2C, 0x00, 0x34, 0x02}},
+			{Command: 0xF7, Params: []byte{0x20}},
+			{Command: 0xEA, Params: []byte{0x00, 0x00}},
+			{Command: 0xC0, Params: []byte{t.PowerControl.GVDD}},
+			{Command: 0xC1, Params: []byte{t.PowerControl.StepUp}},
+			{Command: 0xC5, Params: []byte{t.VCOM.High, t.VCOM.Low}},
+			{Command: 0xC7, Params: []byte{t.VCOM.Offset}},
+			{Command: 0x3A, Params: []byte{pixel_format_rgb565}},
+			{Command: 0x36, Params: []byte{madctl}},
+			{Command: 0xB1, Params: []byte{t.FrameRate.Divider, t.FrameRate.ClocksPerLine}},
+			// Display Function Control, 320 source lines
+			{Command: 0xB6, Params: []byte{0x08, 0x82, 0x27}},
+			{Command: 0x44, Params: []byte{0x02}},
+			{Command: 0xF2, Params: []byte{0x00}},
+			{Command: 0x26, Params: []byte{0x01}},
+			{Command: 0xE0, Params: t.PositiveGamma[:]},
+			{Command: 0xE1, Params: t.NegativeGamma[:]},
+			{Command: 0x29},
+		}, nil
+	case CONTROLLER_ST7789:
+		// the ST7789 uses its own commands for power, gamma and frame rate, Tuning is not used
+		return InitSequence{
+			{Command: 0x11, Delay: config.SleepDelay},                     //Sleep out
+			{Command: 0x3A, Params: []byte{pixel_format_rgb565}},          // Interface Pixel Format
+			{Command: 0x36, Params: []byte{madctl}},                       // Memory Data Access Control
+			{Command: 0xB2, Params: []byte{0x0C, 0x0C, 0x00, 0x33, 0x33}}, // Porch Setting
+			{Command: 0xB7, Params: []byte{0x35}},                         // Gate Control
+			{Command: 0xBB, Params: []byte{0x19}},                         // VCOM Setting
+			{Command: 0xC0, Params: []byte{0x2C}},                         // LCM Control
+			{Command: 0xC2, Params: []byte{0x01}},                         // VDV and VRH Command Enable
+			{Command: 0xC3, Params: []byte{0x12}},                         // VRH Set
+			{Command: 0xC4, Params: []byte{0x20}},                         // VDV Set
+			{Command: 0xC6, Params: []byte{0x0F}},                         // Frame Rate Control, 60Hz
+			{Command: 0xD0, Params: []byte{0xA4, 0xA1}},                   // Power Control 1
+			{Command: 0xE0, Params: []byte{0xD0, 0x04, 0x0D, 0x11, 0x13, 0x2B, 0x3F, 0x54, 0x4C, 0x18, 0x0D, 0x0B, 0x1F, 0x23}},
+			{Command: 0xE1, Params: []byte{0xD0, 0x04, 0x0C, 0x11, 0x13, 0x2C, 0x3F, 0x44, 0x51, 0x2F, 0x1F, 0x1F, 0x20, 0x23}},
+			// most ST7789 modules have an inverted panel
+			{Command: 0x21},
+			{Command: 0x13}, // Normal Display Mode On
+			{Command: 0x29}, //Display on
+		}, nil
+	}
+	return nil, errors.New("invalid controller")
+}
+
+// withPixelSetup returns the sequence with the pixel format and the memory access
+// control it misses, inserted before its final Display On.
+func withPixelSetup(seq InitSequence, madctl byte) InitSequence {
+	var missing []InitStep
+	for _, step := range []InitStep{
+		{Command: 0x3A, Params: []byte{pixel_format_rgb565}},
+		{Command: 0x36, Params: []byte{madctl}},
+	} {
+		if !seq.has(step.Command) {
+			missing = append(missing, step)
+		}
+	}
+	if len(missing) == 0 {
+		return seq
+	}
+	at := len(seq)
+	if at > 0 && seq[at-1].Command == 0x29 {
+		at--
+	}
+	setup := make(InitSequence, 0, len(seq)+len(missing))
+	setup = append(setup, seq[:at]...)
+	setup = append(setup, missing...)
+	return append(setup, seq[at:]...)
+}
+
+func (seq InitSequence) has(cmd byte) bool {
+	for _, step := range seq {
+		if step.Command == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func (dev *Device) runInitSequence(seq InitSequence) error {
+	for _, step := range seq {
+		if err := dev.command(step.Command, step.Params...); err != nil {
+			return fmt.Errorf("init command %x: %w", step.Command, err)
+		}
+		dev.sleep(step.Delay)
+	}
+	return nil
+}
+
+// hasILI9341Tuning tells if the controller understands the commands of PanelTuning.
+func (c Controller) hasILI9341Tuning() bool {
+	return c == CONTROLLER_ILI9341 || c == CONTROLLER_ILI9342
+}
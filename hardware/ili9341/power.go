@@ -0,0 +1,106 @@
+package ili9341
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+// sleep_command_delay is the time the panel needs after Sleep In or Sleep Out
+// before it accepts the next command.
+const sleep_command_delay = 5 * time.Millisecond
+
+// SleepIn turns off the panel's DC/DC converter and oscillator. The memory
+// keeps its content. It waits for Config.SleepDelay since the last Sleep Out.
+func (dev *Device) SleepIn() error {
+	return dev.setSleep(0x10)
+}
+
+// SleepOut wakes the panel up, the picture comes back without sending the frame.
+func (dev *Device) SleepOut() error {
+	return dev.setSleep(0x11)
+}
+
+func (dev *Device) setSleep(cmd byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	// Sleep Out only needs sleep_command_delay since Sleep In, which was waited after it
+	if cmd == 0x10 {
+		dev.sleep(dev.sleepOutAt.Add(dev.config.SleepDelay).Sub(dev.now()))
+	}
+	err := dev.command(cmd)
+	if cmd == 0x11 {
+		dev.sleepOutAt = dev.now()
+	}
+	dev.sleep(sleep_command_delay)
+	return err
+}
+
+// DisplayOn shows the content of the panel memory.
+func (dev *Device) DisplayOn() error {
+	return dev.powerCommand(0x29)
+}
+
+// DisplayOff blanks the screen instantly, drawing and Update keep working.
+func (dev *Device) DisplayOff() error {
+	return dev.powerCommand(0x28)
+}
+
+// SetIdleMode turns the 8 colour idle mode on or off. In idle mode only the most
+// significant bit of each colour component is shown, which reduces the power.
+func (dev *Device) SetIdleMode(on bool) error {
+	if on {
+		return dev.powerCommand(0x39)
+	}
+	return dev.powerCommand(0x38)
+}
+
+// SetInversion turns the display inversion on or off.
+func (dev *Device) SetInversion(on bool) error {
+	if on {
+		return dev.powerCommand(0x21)
+	}
+	return dev.powerCommand(0x20)
+}
+
+func (dev *Device) powerCommand(cmd byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	return dev.command(cmd)
+}
+
+// SetBacklightPWM sets the pin of a backlight dimmed with pulse width modulation.
+func (dev *Device) SetBacklightPWM(pin gpio.GPIOPinPWM) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.backlight = pin.PWM
+}
+
+// SetBacklightPin sets the pin of a backlight which is only switched on and off.
+func (dev *Device) SetBacklightPin(pin gpio.GPIOPinOut) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.backlight = func(brightness float64) error {
+		pin.Out(brightness > 0)
+		return nil
+	}
+}
+
+// SetBrightness sets the backlight from 0, off, to 1, full brightness.
+// A backlight without PWM is on for any brightness above 0.
+func (dev *Device) SetBrightness(brightness float64) error {
+	if brightness < 0 || brightness > 1 {
+		return errors.New("invalid brightness")
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.backlight == nil {
+		return errors.New("no backlight pin")
+	}
+	return dev.backlight(brightness)
+}
@@ -0,0 +1,168 @@
+package ili9341
+
+import (
+	"errors"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+// ILI9341_MODEL is the IC model read with ReadModel from an ILI9341.
+const ILI9341_MODEL uint16 = 0x9341
+
+// DisplayID is the answer to Read Display Identification (0x04).
+type DisplayID struct {
+	Manufacturer byte
+	Version      byte
+	Module       byte
+}
+
+// DisplayStatus is the answer to Read Display Status (0x09).
+type DisplayStatus struct {
+	BoosterOn           bool
+	MemoryAccessControl byte // MY, MX, MV, ML, BGR and MH bits as in MADCTL
+	PixelFormat         byte // 5 for 16 bits, 6 for 18 bits per pixel
+	IdleMode            bool
+	PartialMode         bool
+	SleepOut            bool
+	NormalMode          bool
+	VerticalScrolling   bool
+	Inversion           bool
+	DisplayOn           bool
+	TearingEffect       bool
+}
+
+// PowerMode is the answer to Read Display Power Mode (0x0A).
+type PowerMode struct {
+	BoosterOn   bool
+	IdleMode    bool
+	PartialMode bool
+	SleepOut    bool
+	NormalMode  bool
+	DisplayOn   bool
+}
+
+func (dev *Device) ReadDisplayID() (DisplayID, error) {
+	data, err := dev.read(0x04, 3)
+	if err != nil {
+		return DisplayID{}, err
+	}
+	return DisplayID{Manufacturer: data[0], Version: data[1], Module: data[2]}, nil
+}
+
+// ReadModel reads the IC model with Read ID4 (0xD3), ILI9341_MODEL for an ILI9341.
+// 0x0000 or 0xFFFF usually means the MISO line is not connected.
+func (dev *Device) ReadModel() (uint16, error) {
+	data, err := dev.read(0xD3, 3)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[1])<<8 | uint16(data[2]), nil
+}
+
+func (dev *Device) ReadStatus() (DisplayStatus, error) {
+	data, err := dev.read(0x09, 4)
+	if err != nil {
+		return DisplayStatus{}, err
+	}
+	status := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	bit := func(n int) bool {
+		return status&(1<<n) != 0
+	}
+	return DisplayStatus{
+		BoosterOn:           bit(31),
+		MemoryAccessControl: byte(status>>23) & 0xFC,
+		PixelFormat:         byte(status>>20) & 0x07,
+		IdleMode:            bit(19),
+		PartialMode:         bit(18),
+		SleepOut:            bit(17),
+		NormalMode:          bit(16),
+		VerticalScrolling:   bit(15),
+		Inversion:           bit(13),
+		DisplayOn:           bit(10),
+		TearingEffect:       bit(9),
+	}, nil
+}
+
+func (dev *Device) ReadPowerMode() (PowerMode, error) {
+	data, err := dev.read(0x0A, 1)
+	if err != nil {
+		return PowerMode{}, err
+	}
+	mode := data[0]
+	return PowerMode{
+		BoosterOn:   mode&0x80 != 0,
+		IdleMode:    mode&0x40 != 0,
+		PartialMode: mode&0x20 != 0,
+		SleepOut:    mode&0x10 != 0,
+		NormalMode:  mode&0x08 != 0,
+		DisplayOn:   mode&0x04 != 0,
+	}, nil
+}
+
+// ReadGRAM reads a width x height rectangle of the panel memory, row by row, into
+// pixels. The coordinates are on the screen for the current rotation and are not
+// moved by scrolling. The panel sends 18 bits per pixel, the low bits of red and
+// blue are dropped to get RGB565, so the pixels written by the driver read back unchanged.
+func (dev *Device) ReadGRAM(x, y, width, height int, pixels []colors.RGB565) error {
+	if x < 0 || y < 0 || width <= 0 || height <= 0 {
+		return errors.New("invalid read area")
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if x+width > dev.width || y+height > dev.height {
+		return errors.New("invalid read area")
+	}
+	if len(pixels) < width*height {
+		return errors.New("pixel buffer is too small")
+	}
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	if err := dev.setAddress(x, y, x+width-1, y+height-1); err != nil {
+		return err
+	}
+	// the reads are split in chunks, each chunk starts with a dummy byte
+	perChunk := (dev.config.SPIChunkSize - 1) / 3
+	if perChunk < 1 {
+		perChunk = 1
+	}
+	cmd := byte(0x2E)
+	for n := 0; n < width*height; n += perChunk {
+		count := width*height - n
+		if count > perChunk {
+			count = perChunk
+		}
+		data, err := dev.readCommand(cmd, count*3)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			rgb := data[i*3:]
+			c := colors.RGB565(rgb[0]>>3)<<11 | colors.RGB565(rgb[1]>>2)<<5 | colors.RGB565(rgb[2]>>3)
+			pixels[n+i] = dev.toPanelColor(c)
+		}
+		// Memory Read Continue
+		cmd = 0x3E
+	}
+	return nil
+}
+
+func (dev *Device) read(cmd byte, n int) ([]byte, error) {
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	return dev.readCommand(cmd, n)
+}
+
+// readCommand sends a read command and returns the n bytes after the dummy byte.
+func (dev *Device) readCommand(cmd byte, n int) ([]byte, error) {
+	if err := dev.writeCommand(cmd); err != nil {
+		return nil, err
+	}
+	dev.pinDC.Out(gpio.High)
+	w := make([]byte, n+1)
+	r := make([]byte, n+1)
+	if err := dev.tx(w, r); err != nil {
+		return nil, err
+	}
+	return r[1:], nil
+}
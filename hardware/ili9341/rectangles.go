@@ -0,0 +1,109 @@
+package ili9341
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	max_changed_rectangles int = 16
+	// rectangle_merge_overhead is the number of extra pixels worth sending to save
+	// the setup of a separate window, which costs about 11 bytes in 5 transfers.
+	rectangle_merge_overhead int = 32
+)
+
+// rectangle is an area of the screen in pixels, inclusive.
+type rectangle struct {
+	x1, y1, x2, y2 int
+}
+
+func (r rectangle) area() int {
+	return (r.x2 - r.x1 + 1) * (r.y2 - r.y1 + 1)
+}
+
+func (r rectangle) contains(o rectangle) bool {
+	return o.x1 >= r.x1 && o.x2 <= r.x2 && o.y1 >= r.y1 && o.y2 <= r.y2
+}
+
+func (r rectangle) union(o rectangle) rectangle {
+	u := r
+	if o.x1 < u.x1 {
+		u.x1 = o.x1
+	}
+	if o.y1 < u.y1 {
+		u.y1 = o.y1
+	}
+	if o.x2 > u.x2 {
+		u.x2 = o.x2
+	}
+	if o.y2 > u.y2 {
+		u.y2 = o.y2
+	}
+	return u
+}
+
+// growth is the number of unchanged pixels sent if r and o are merged.
+func (r rectangle) growth(o rectangle) int {
+	return r.union(o).area() - r.area() - o.area()
+}
+
+// markRectangle adds a changed area. It is merged with the existing rectangle
+// that grows the least, if the growth is cheaper than a separate window.
+// When there are too many rectangles the closest two are merged.
+func (f *frame) markRectangle(r rectangle) {
+	for {
+		best, bestGrowth := -1, 0
+		for i, c := range f.rectangles {
+			if c.contains(r) {
+				return
+			}
+			growth := c.growth(r)
+			if growth <= rectangle_merge_overhead && (best < 0 || growth < bestGrowth) {
+				best, bestGrowth = i, growth
+			}
+		}
+		if best < 0 {
+			break
+		}
+		r = r.union(f.rectangles[best])
+		f.removeRectangle(best)
+	}
+	f.rectangles = append(f.rectangles, r)
+	if len(f.rectangles) > max_changed_rectangles {
+		f.mergeClosestRectangles()
+	}
+}
+
+func (f *frame) mergeClosestRectangles() {
+	bi, bj, bestGrowth := 0, 1, f.rectangles[0].growth(f.rectangles[1])
+	for i := 0; i < len(f.rectangles); i++ {
+		for j := i + 1; j < len(f.rectangles); j++ {
+			if growth := f.rectangles[i].growth(f.rectangles[j]); growth < bestGrowth {
+				bi, bj, bestGrowth = i, j, growth
+			}
+		}
+	}
+	r := f.rectangles[bi].union(f.rectangles[bj])
+	f.removeRectangle(bj)
+	f.removeRectangle(bi)
+	f.markRectangle(r)
+}
+
+func (f *frame) removeRectangle(i int) {
+	last := len(f.rectangles) - 1
+	f.rectangles[i] = f.rectangles[last]
+	f.rectangles = f.rectangles[:last]
+}
+
+func (dev *Device) updateRectangles(ctx context.Context, f *frame) (int, error) {
+	counter := 0
+	for len(f.rectangles) > 0 {
+		r := f.rectangles[0]
+		if err := dev.refreshArea(ctx, f, r); err != nil {
+			return counter, fmt.Errorf("rectangle %d,%d-%d,%d: %w", r.x1, r.y1, r.x2, r.y2, err)
+		}
+		f.removeRectangle(0)
+		counter++
+	}
+	return counter, nil
+}
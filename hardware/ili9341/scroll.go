@@ -0,0 +1,144 @@
+package ili9341
+
+import "errors"
+
+// scrollArea is the hardware scrolling area in panel lines.
+type scrollArea struct {
+	top    int // lines of the top fixed area
+	lines  int // lines of the scrolling area
+	offset int // 0 <= offset < lines
+}
+
+// memoryLine returns the line of the panel memory shown on a panel line.
+func (s scrollArea) memoryLine(line int) int {
+	if s.offset == 0 || line < s.top || line >= s.top+s.lines {
+		return line
+	}
+	return s.top + (line-s.top+s.offset)%s.lines
+}
+
+// SetScrollArea defines the scrolling area between a top and a bottom fixed area
+// and resets the scroll offset. The sizes are in panel lines, along the long side
+// of the panel from the first line of the panel memory. The lines are vertical on
+// the screen with the landscape rotations and horizontal with the portrait ones.
+// Scrolling needs a panel as long as the memory.
+func (dev *Device) SetScrollArea(topFixed, bottomFixed int) error {
+	lines := dev.config.Width - topFixed - bottomFixed
+	if topFixed < 0 || bottomFixed < 0 || lines <= 0 {
+		return errors.New("invalid scroll area")
+	}
+	if err := dev.config.canScroll(); err != nil {
+		return err
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	err := dev.command(0x33,
+		byte(topFixed>>8), byte(topFixed),
+		byte(lines>>8), byte(lines),
+		byte(bottomFixed>>8), byte(bottomFixed),
+	)
+	if err != nil {
+		return err
+	}
+	dev.scroll = scrollArea{top: topFixed, lines: lines}
+	return dev.sendScrollStart()
+}
+
+// Scroll moves the content of the scrolling area by offset lines towards the top
+// fixed area, without sending the frame again. Coordinates stay logical: drawing
+// after Scroll addresses the screen as it is seen. The lines which scroll in keep
+// their old content until they are drawn.
+func (dev *Device) Scroll(offset int) error {
+	if err := dev.config.canScroll(); err != nil {
+		return err
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	if dev.scroll.lines == 0 {
+		dev.scroll = scrollArea{top: 0, lines: dev.config.Width}
+	}
+	offset %= dev.scroll.lines
+	if offset < 0 {
+		offset += dev.scroll.lines
+	}
+	old := dev.scroll.offset
+	dev.scroll.offset = offset
+	if err := dev.sendScrollStart(); err != nil {
+		dev.scroll.offset = old
+		return err
+	}
+	return nil
+}
+
+// ScrollOffset returns the current scroll offset, 0 <= offset < scrolling area lines.
+func (dev *Device) ScrollOffset() int {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.scroll.offset
+}
+
+func (c Config) canScroll() error {
+	if _, rows := c.memorySize(); rows != c.Width {
+		return errors.New("scrolling is not supported when the panel is shorter than the memory")
+	}
+	return nil
+}
+
+func (dev *Device) sendScrollStart() error {
+	start := dev.scroll.top + dev.scroll.offset
+	return dev.command(0x37, byte(start>>8), byte(start))
+}
+
+// scrolled maps screen coordinates to the buffer, which follows the panel memory.
+func (dev *Device) scrolled(x, y int) (int, int) {
+	if dev.scroll.offset == 0 {
+		return x, y
+	}
+	madctl := memory_access_controls[dev.rotation]
+	col, row := dev.toPanel(x, y, madctl)
+	return dev.fromPanel(col, dev.scroll.memoryLine(row), madctl)
+}
+
+// scrolledRectangles splits a rectangle of the screen into the rectangles of
+// the buffer it is mapped to. Scrolling moves each piece as a whole.
+func (dev *Device) scrolledRectangles(r rectangle) []rectangle {
+	dev.scrollRects = append(dev.scrollRects[:0], r)
+	if dev.scroll.offset == 0 {
+		return dev.scrollRects
+	}
+	dev.scrollRects = dev.scrollRects[:0]
+	alongX := memory_access_controls[dev.rotation]&row_col_exchange != 0
+	a1, a2 := r.y1, r.y2
+	if alongX {
+		a1, a2 = r.x1, r.x2
+	}
+	shift := func(a int) int {
+		if alongX {
+			x, _ := dev.scrolled(a, 0)
+			return x - a
+		}
+		_, y := dev.scrolled(0, a)
+		return y - a
+	}
+	start, d := a1, shift(a1)
+	for a := a1 + 1; a <= a2+1; a++ {
+		if a <= a2 && shift(a) == d {
+			continue
+		}
+		piece := r
+		if alongX {
+			piece.x1, piece.x2 = start+d, a-1+d
+		} else {
+			piece.y1, piece.y2 = start+d, a-1+d
+		}
+		dev.scrollRects = append(dev.scrollRects, piece)
+		if a <= a2 {
+			start, d = a, shift(a)
+		}
+	}
+	return dev.scrollRects
+}
@@ -0,0 +1,41 @@
+package ili9341
+
+// FrameStats are the statistics of a frame, from an Update to the next one.
+type FrameStats struct {
+	PixelsWritten     int // pixels drawn on the screen
+	PixelsChanged     int // pixels whose colour actually changed
+	SegmentsFlushed   int // segments sent with DIRTY_SEGMENTS tracking
+	RectanglesFlushed int // rectangles sent with DIRTY_RECTANGLES tracking
+	BytesSent         int // bytes sent to the SPI bus by the Update, including its commands
+}
+
+// Stats returns the statistics of the last updated frame. With DoubleBuffered
+// the frame is the last one whose transfer has completed.
+func (dev *Device) Stats() FrameStats {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.collectTransfer()
+	return dev.lastStats
+}
+
+// BytesSent returns the number of bytes sent for the last updated frame.
+func (dev *Device) BytesSent() int {
+	return dev.Stats().BytesSent
+}
+
+func (dev *Device) tx(w, r []byte) error {
+	dev.bytesSent += len(w)
+	return dev.conn.Tx(w, r)
+}
+
+// frameStats completes the statistics of a flushed frame with the bus counters.
+func (dev *Device) frameStats(stats FrameStats, counter int) FrameStats {
+	if dev.config.DirtyTracking == DIRTY_RECTANGLES {
+		stats.RectanglesFlushed = counter
+	} else {
+		stats.SegmentsFlushed = counter
+	}
+	stats.BytesSent = dev.bytesSent
+	dev.bytesSent = 0
+	return stats
+}
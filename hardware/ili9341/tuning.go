@@ -0,0 +1,158 @@
+package ili9341
+
+import (
+	"bytes"
+	"errors"
+)
+
+type TuningPreset int
+
+// TuningPreset selects a known set of analog settings. TUNING_DEFAULT is the
+// setup the driver always used, TUNING_ADAFRUIT the one of the Adafruit ILI9341
+// library and TUNING_LOW_POWER is TUNING_DEFAULT with a lower frame rate.
+const (
+	TUNING_DEFAULT   TuningPreset = 0
+	TUNING_ADAFRUIT  TuningPreset = 1
+	TUNING_LOW_POWER TuningPreset = 2
+)
+
+// oscillator_frequency is the internal clock of the panel in Hz.
+const oscillator_frequency float64 = 615000
+
+// PowerControl sets the voltages of the panel.
+type PowerControl struct {
+	GVDD   byte // VRH[5:0] of Power Control 1 (0xC0), 0x03 to 0x3F
+	StepUp byte // Power Control 2 (0xC1), BT[2:0] is the step up factor
+}
+
+// VCOM sets the common electrode voltage, which changes the flicker and contrast.
+type VCOM struct {
+	High   byte // VMH[6:0] of VCOM Control 1 (0xC5)
+	Low    byte // VML[6:0] of VCOM Control 1 (0xC5)
+	Offset byte // nVM and VMF[6:0] of VCOM Control 2 (0xC7)
+}
+
+// FrameRate is the Frame Rate Control in normal mode (0xB1).
+type FrameRate struct {
+	Divider       byte // DIVA[1:0], the oscillator is divided by 2^Divider
+	ClocksPerLine byte // RTNA[4:0], 0x10 to 0x1F clocks
+}
+
+// Hz returns the frame rate with the default 320 lines and 4 porch lines.
+func (f FrameRate) Hz() float64 {
+	return oscillator_frequency / float64(int(f.ClocksPerLine)<<f.Divider*(320+4))
+}
+
+// PanelTuning is the analog setup of the panel, which differs between suppliers.
+type PanelTuning struct {
+	PositiveGamma Gamma
+	NegativeGamma Gamma
+	PowerControl  PowerControl
+	VCOM          VCOM
+	FrameRate     FrameRate
+}
+
+func PresetTuning(preset TuningPreset) (PanelTuning, error) {
+	tuning := PanelTuning{
+		PositiveGamma: Gamma{0x0F, 0x22, 0x1C, 0x1B, 0x08, 0x0F, 0x48, 0xB8, 0x34, 0x05, 0x0C, 0x09, 0x0F, 0x07, 0x00},
+		NegativeGamma: Gamma{0x00, 0x23, 0x24, 0x07, 0x10, 0x07, 0x38, 0x47, 0x4B, 0x0A, 0x13, 0x06, 0x30, 0x38, 0x0F},
+		PowerControl:  PowerControl{GVDD: 0x1D, StepUp: 0x12},
+		VCOM:          VCOM{High: 0x33, Low: 0x3F, Offset: 0x92},
+		FrameRate:     FrameRate{Divider: 0, ClocksPerLine: 0x12},
+	}
+	switch preset {
+	case TUNING_DEFAULT:
+	case TUNING_ADAFRUIT:
+		tuning = PanelTuning{
+			PositiveGamma: Gamma{0x0F, 0x31, 0x2B, 0x0C, 0x0E, 0x08, 0x4E, 0xF1, 0x37, 0x07, 0x10, 0x03, 0x0E, 0x09, 0x00},
+			NegativeGamma: Gamma{0x00, 0x0E, 0x14, 0x03, 0x11, 0x07, 0x31, 0xC1, 0x48, 0x08, 0x0F, 0x0C, 0x31, 0x36, 0x0F},
+			PowerControl:  PowerControl{GVDD: 0x23, StepUp: 0x10},
+			VCOM:          VCOM{High: 0x3E, Low: 0x28, Offset: 0x86},
+			FrameRate:     FrameRate{Divider: 0, ClocksPerLine: 0x18},
+		}
+	case TUNING_LOW_POWER:
+		tuning.FrameRate = FrameRate{Divider: 1, ClocksPerLine: 0x1F}
+	default:
+		return PanelTuning{}, errors.New("invalid tuning preset")
+	}
+	return tuning, nil
+}
+
+func (t PanelTuning) validate() error {
+	if t.PowerControl.GVDD < 0x03 || t.PowerControl.GVDD > 0x3F {
+		return errors.New("invalid power control")
+	}
+	if t.VCOM.High > 0x7F || t.VCOM.Low > 0x7F {
+		return errors.New("invalid vcom")
+	}
+	if t.FrameRate.Divider > 0x03 || t.FrameRate.ClocksPerLine < 0x10 || t.FrameRate.ClocksPerLine > 0x1F {
+		return errors.New("invalid frame rate")
+	}
+	return nil
+}
+
+// Tuning returns the analog setup of the panel.
+func (dev *Device) Tuning() PanelTuning {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.config.Tuning
+}
+
+// SetTuning sends the whole analog setup to the panel.
+func (dev *Device) SetTuning(tuning PanelTuning) error {
+	return dev.changeTuning(func(t *PanelTuning) { *t = tuning }, 0xC0, 0xC1, 0xC5, 0xC7, 0xB1, 0xE0, 0xE1)
+}
+
+func (dev *Device) SetGamma(positive, negative Gamma) error {
+	return dev.changeTuning(func(t *PanelTuning) { t.PositiveGamma, t.NegativeGamma = positive, negative }, 0xE0, 0xE1)
+}
+
+func (dev *Device) SetPowerControl(power PowerControl) error {
+	return dev.changeTuning(func(t *PanelTuning) { t.PowerControl = power }, 0xC0, 0xC1)
+}
+
+func (dev *Device) SetVCOM(vcom VCOM) error {
+	return dev.changeTuning(func(t *PanelTuning) { t.VCOM = vcom }, 0xC5, 0xC7)
+}
+
+func (dev *Device) SetFrameRate(rate FrameRate) error {
+	return dev.changeTuning(func(t *PanelTuning) { t.FrameRate = rate }, 0xB1)
+}
+
+// changeTuning changes the tuning and sends the given commands of the new setup.
+func (dev *Device) changeTuning(change func(t *PanelTuning), cmds ...byte) error {
+	if !dev.config.Controller.hasILI9341Tuning() {
+		return errors.New("tuning is not supported by the controller")
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	tuning := dev.config.Tuning
+	change(&tuning)
+	if err := tuning.validate(); err != nil {
+		return err
+	}
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	for _, c := range tuningCommands(tuning) {
+		if !bytes.Contains(cmds, c[:1]) {
+			continue
+		}
+		if err := dev.command(c[0], c[1:]...); err != nil {
+			return err
+		}
+	}
+	dev.config.Tuning = tuning
+	return nil
+}
+
+func tuningCommands(t PanelTuning) [][]byte {
+	return [][]byte{
+		{0xC0, t.PowerControl.GVDD},                            //Power control VRH[5:0]
+		{0xC1, t.PowerControl.StepUp},                          //Power control SAP[2:0];BT[3:0]
+		{0xC5, t.VCOM.High, t.VCOM.Low},                        //VCM control
+		{0xC7, t.VCOM.Offset},                                  //VCM control
+		{0xB1, t.FrameRate.Divider, t.FrameRate.ClocksPerLine}, // Frame Rate Control
+		append([]byte{0xE0}, t.PositiveGamma[:]...),            //Set Gamma
+		append([]byte{0xE1}, t.NegativeGamma[:]...),            //Set Gamma
+	}
+}
@@ -0,0 +1,303 @@
+package ili9341
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+// frame is a buffer of the screen with its changed parts.
+type frame struct {
+	segments         []byte
+	isSegmentChanged []bool
+	rectangles       []rectangle
+}
+
+// transfer is a frame being sent in the background.
+type transfer struct {
+	done  chan struct{}
+	stats FrameStats
+	err   error
+}
+
+// segmentWindow is a rectangle of segments, in segment units and inclusive.
+type segmentWindow struct {
+	x1, y1, x2, y2 int
+}
+
+var closed_channel = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+func (dev *Device) newFrame() *frame {
+	numOfSegments := dev.numXSeg * dev.numYSeg
+	return &frame{
+		segments:         make([]byte, numOfSegments*dev.bytesPerSegment),
+		isSegmentChanged: make([]bool, numOfSegments),
+		rectangles:       make([]rectangle, 0, max_changed_rectangles+1),
+	}
+}
+
+func (dev *Device) Update() int {
+	counter, _ := dev.UpdateErr()
+	return counter
+}
+
+// UpdateErr sends the changes to the panel and returns the number of updated
+// segments, or rectangles with DIRTY_RECTANGLES tracking. It stops at the first
+// failed transfer and the parts which are not sent stay changed.
+func (dev *Device) UpdateErr() (int, error) {
+	return dev.UpdateContext(context.Background())
+}
+
+// UpdateContext is UpdateErr with a context which stops the transfer between chunks.
+// With DoubleBuffered it waits for the previous transfer, swaps the buffers and
+// returns the number of handed over segments or rectangles without waiting for
+// the new transfer. The error is then the one of the previous transfer.
+func (dev *Device) UpdateContext(ctx context.Context) (int, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if !dev.config.DoubleBuffered {
+		dev.busMu.Lock()
+		defer dev.busMu.Unlock()
+		counter, err := dev.flush(ctx, dev.frame)
+		dev.lastStats = dev.frameStats(dev.stats, counter)
+		dev.stats = FrameStats{}
+		return counter, err
+	}
+	dev.waitTransfer()
+	err := dev.transferErr
+	dev.transferErr = nil
+	dev.frame, dev.front = dev.front, dev.frame
+	counter := dev.copyChanges(dev.frame, dev.front)
+	t := &transfer{done: make(chan struct{}), stats: dev.stats}
+	dev.stats = FrameStats{}
+	dev.transfer = t
+	go dev.send(ctx, t, dev.front)
+	return counter, err
+}
+
+// Wait waits for the background transfer and returns its error.
+func (dev *Device) Wait() error {
+	<-dev.Done()
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.collectTransfer()
+	err := dev.transferErr
+	dev.transferErr = nil
+	return err
+}
+
+// Done returns a channel which is closed when the background transfer is complete.
+func (dev *Device) Done() <-chan struct{} {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.transfer == nil {
+		return closed_channel
+	}
+	return dev.transfer.done
+}
+
+func (dev *Device) send(ctx context.Context, t *transfer, f *frame) {
+	dev.busMu.Lock()
+	defer dev.busMu.Unlock()
+	counter, err := dev.flush(ctx, f)
+	t.stats = dev.frameStats(t.stats, counter)
+	t.err = err
+	close(t.done)
+}
+
+func (dev *Device) waitTransfer() {
+	if dev.transfer != nil {
+		<-dev.transfer.done
+		dev.collectTransfer()
+	}
+}
+
+// collectTransfer keeps the result of a completed background transfer.
+func (dev *Device) collectTransfer() {
+	if dev.transfer == nil {
+		return
+	}
+	select {
+	case <-dev.transfer.done:
+		dev.lastStats = dev.transfer.stats
+		dev.transferErr = dev.transfer.err
+		dev.transfer = nil
+	default:
+	}
+}
+
+// copyChanges copies the changed parts of src into dst, so both buffers hold the
+// same picture. The parts of dst which are not sent yet, after a failed transfer,
+// are moved to src. It returns the number of changed segments or rectangles of src.
+func (dev *Device) copyChanges(dst, src *frame) int {
+	if dev.config.DirtyTracking == DIRTY_RECTANGLES {
+		for _, r := range src.rectangles {
+			for y := r.y1; y <= r.y2; y++ {
+				for x := r.x1; x <= r.x2; {
+					n := dev.rowRun(x, r.x2)
+					i := dev.offset(x, y)
+					copy(dst.segments[i:i+n*2], src.segments[i:i+n*2])
+					x += n
+				}
+			}
+		}
+		for _, r := range dst.rectangles {
+			src.markRectangle(r)
+		}
+		dst.rectangles = dst.rectangles[:0]
+		return len(src.rectangles)
+	}
+	counter := 0
+	for seg, changed := range src.isSegmentChanged {
+		if changed {
+			i := seg * dev.bytesPerSegment
+			copy(dst.segments[i:i+dev.bytesPerSegment], src.segments[i:i+dev.bytesPerSegment])
+		}
+		if changed || dst.isSegmentChanged[seg] {
+			src.isSegmentChanged[seg] = true
+			dst.isSegmentChanged[seg] = false
+			counter++
+		}
+	}
+	return counter
+}
+
+func (dev *Device) flush(ctx context.Context, f *frame) (int, error) {
+	// the commands sent since the last flush are not part of the frame
+	dev.bytesSent = 0
+	if dev.config.DirtyTracking == DIRTY_RECTANGLES {
+		return dev.updateRectangles(ctx, f)
+	}
+	return dev.updateSegments(ctx, f)
+}
+
+// updateSegments merges adjacent changed segments into rectangular windows
+// which are sent with a single memory write.
+func (dev *Device) updateSegments(ctx context.Context, f *frame) (int, error) {
+	counter := 0
+	for _, w := range dev.changedWindows(f) {
+		r := rectangle{
+			x1: w.x1 * dev.segmentWidth,
+			y1: w.y1 * dev.segmentHeight,
+			x2: (w.x2+1)*dev.segmentWidth - 1,
+			y2: (w.y2+1)*dev.segmentHeight - 1,
+		}
+		if err := dev.refreshArea(ctx, f, r); err != nil {
+			return counter, fmt.Errorf("segment %d: %w", w.y1*dev.numXSeg+w.x1, err)
+		}
+		for yseg := w.y1; yseg <= w.y2; yseg++ {
+			for xseg := w.x1; xseg <= w.x2; xseg++ {
+				f.isSegmentChanged[yseg*dev.numXSeg+xseg] = false
+				counter++
+			}
+		}
+	}
+	return counter, nil
+}
+
+// changedWindows greedily merges the changed segments into windows. Each window
+// grows to the right as far as possible and then down while the whole row below is changed.
+func (dev *Device) changedWindows(f *frame) []segmentWindow {
+	dev.windows = dev.windows[:0]
+	for seg := range dev.isSegmentTaken {
+		dev.isSegmentTaken[seg] = false
+	}
+	isFree := func(xseg, yseg int) bool {
+		seg := yseg*dev.numXSeg + xseg
+		return f.isSegmentChanged[seg] && !dev.isSegmentTaken[seg]
+	}
+	for yseg := 0; yseg < dev.numYSeg; yseg++ {
+		for xseg := 0; xseg < dev.numXSeg; xseg++ {
+			if !isFree(xseg, yseg) {
+				continue
+			}
+			w := segmentWindow{x1: xseg, y1: yseg, x2: xseg, y2: yseg}
+			for w.x2+1 < dev.numXSeg && isFree(w.x2+1, yseg) {
+				w.x2++
+			}
+			for w.y2+1 < dev.numYSeg {
+				free := true
+				for x := w.x1; x <= w.x2 && free; x++ {
+					free = isFree(x, w.y2+1)
+				}
+				if !free {
+					break
+				}
+				w.y2++
+			}
+			for y := w.y1; y <= w.y2; y++ {
+				for x := w.x1; x <= w.x2; x++ {
+					dev.isSegmentTaken[y*dev.numXSeg+x] = true
+				}
+			}
+			dev.windows = append(dev.windows, w)
+			xseg = w.x2
+		}
+	}
+	return dev.windows
+}
+
+func (dev *Device) refreshArea(ctx context.Context, f *frame, r rectangle) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := dev.setWindow(r.x1, r.y1, r.x2, r.y2); err != nil {
+		return err
+	}
+	dev.pinDC.Out(gpio.High)
+	dev.streamBuf = dev.streamBuf[:0]
+	for y := r.y1; y <= r.y2; y++ {
+		for x := r.x1; x <= r.x2; {
+			n := dev.rowRun(x, r.x2)
+			i := dev.offset(x, y)
+			if err := dev.stream(ctx, f.segments[i:i+n*2]); err != nil {
+				return err
+			}
+			x += n
+		}
+	}
+	return dev.flushStream()
+}
+
+// rowRun returns the number of pixels from x to x2 which are contiguous in the
+// buffer, the pixels of a row are contiguous up to the end of the segment.
+func (dev *Device) rowRun(x, x2 int) int {
+	n := dev.segmentWidth - x%dev.segmentWidth
+	if x+n-1 > x2 {
+		n = x2 - x + 1
+	}
+	return n
+}
+
+// stream buffers pixel data and sends it in transfers of at most SPIChunkSize bytes.
+func (dev *Device) stream(ctx context.Context, data []byte) error {
+	for len(data) > 0 {
+		n := len(dev.streamBuf)
+		m := copy(dev.streamBuf[n:cap(dev.streamBuf)], data)
+		dev.streamBuf = dev.streamBuf[:n+m]
+		data = data[m:]
+		if len(dev.streamBuf) == cap(dev.streamBuf) {
+			if err := dev.flushStream(); err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dev *Device) flushStream() error {
+	if len(dev.streamBuf) == 0 {
+		return nil
+	}
+	err := dev.tx(dev.streamBuf, nil)
+	dev.streamBuf = dev.streamBuf[:0]
+	return err
+}
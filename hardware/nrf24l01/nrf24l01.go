@@ -0,0 +1,771 @@
+// Package nrf24l01 drives the NRF24L01 2.4GHz transceiver with its enhanced
+// shockburst protocol: the radio acknowledges and retransmits the packets.
+// CE starts the transmissions and the reception, IRQ is low while an
+// interrupt is pending.
+package nrf24l01
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+const (
+	MAX_PAYLOAD_SIZE = 32
+	MAX_CHANNEL      = 125
+	PIPES            = 6
+)
+
+// Registers
+const (
+	REG_CONFIG      byte = 0x00
+	REG_EN_AA       byte = 0x01
+	REG_EN_RXADDR   byte = 0x02
+	REG_SETUP_AW    byte = 0x03
+	REG_SETUP_RETR  byte = 0x04
+	REG_RF_CH       byte = 0x05
+	REG_RF_SETUP    byte = 0x06
+	REG_STATUS      byte = 0x07
+	REG_OBSERVE_TX  byte = 0x08
+	REG_RPD         byte = 0x09
+	REG_RX_ADDR_P0  byte = 0x0A
+	REG_RX_ADDR_P1  byte = 0x0B
+	REG_RX_ADDR_P2  byte = 0x0C
+	REG_RX_ADDR_P3  byte = 0x0D
+	REG_RX_ADDR_P4  byte = 0x0E
+	REG_RX_ADDR_P5  byte = 0x0F
+	REG_TX_ADDR     byte = 0x10
+	REG_RX_PW_P0    byte = 0x11
+	REG_FIFO_STATUS byte = 0x17
+	REG_DYNPD       byte = 0x1C
+	REG_FEATURE     byte = 0x1D
+)
+
+const (
+	cmd_r_register   byte = 0x00
+	cmd_w_register   byte = 0x20
+	cmd_activate     byte = 0x50
+	cmd_r_rx_pl_wid  byte = 0x60
+	cmd_r_rx_payload byte = 0x61
+	cmd_w_tx_payload byte = 0xA0
+	cmd_flush_tx     byte = 0xE1
+	cmd_flush_rx     byte = 0xE2
+	cmd_nop          byte = 0xFF
+
+	config_en_crc  byte = 1 << 3
+	config_crco    byte = 1 << 2
+	config_pwr_up  byte = 1 << 1
+	config_prim_rx byte = 1 << 0
+
+	status_rx_dr  byte = 1 << 6
+	status_tx_ds  byte = 1 << 5
+	status_max_rt byte = 1 << 4
+	status_tx_ful byte = 1 << 0
+
+	rf_setup_dr_low   byte = 1 << 5
+	rf_setup_dr_high  byte = 1 << 3
+	feature_en_dpl    byte = 1 << 2
+	activate_features byte = 0x73
+	all_pipes         byte = 1<<PIPES - 1
+
+	// CE must stay high at least 10µs to send a packet
+	ce_pulse        = 15 * time.Microsecond
+	send_poll_delay = 50 * time.Microsecond
+	send_timeout    = 100 * time.Millisecond
+)
+
+type DataRate int
+
+const (
+	DATA_RATE_1MBPS   DataRate = 0
+	DATA_RATE_2MBPS   DataRate = 1
+	DATA_RATE_250KBPS DataRate = 2
+)
+
+type PALevel int
+
+// PALevel is the output power, from -18dBm for PA_LEVEL_MIN to 0dBm for PA_LEVEL_MAX.
+const (
+	PA_LEVEL_MIN  PALevel = 0
+	PA_LEVEL_LOW  PALevel = 1
+	PA_LEVEL_HIGH PALevel = 2
+	PA_LEVEL_MAX  PALevel = 3
+)
+
+type CRCLength int
+
+const (
+	CRC_DISABLED CRCLength = 0
+	CRC_8        CRCLength = 1
+	CRC_16       CRCLength = 2
+)
+
+// ErrMaxRetries is returned by Send when no acknowledgement came back after the
+// last retransmission. The packet is flushed.
+var ErrMaxRetries = errors.New("no acknowledgement after the maximum retransmissions")
+
+// ErrSendTimeout is returned by Send when the radio reported neither the
+// acknowledgement nor the last retransmission in time. The packet is flushed.
+var ErrSendTimeout = errors.New("send timeout")
+
+// ErrNoPayload is returned by Receive when the RX FIFO is empty.
+var ErrNoPayload = errors.New("no payload received")
+
+type Config struct {
+	Channel         int // 2400MHz + Channel MHz, from 0 to MAX_CHANNEL
+	DataRate        DataRate
+	PALevel         PALevel
+	CRC             CRCLength
+	AddressWidth    int  // bytes of the addresses, from 3 to 5
+	AutoAck         bool // acknowledgement and retransmission on all pipes
+	RetransmitDelay time.Duration
+	RetransmitCount int  // from 0 to 15
+	PayloadSize     int  // size of the static payloads
+	DynamicPayloads bool // payloads of 1 to MAX_PAYLOAD_SIZE bytes on all pipes, needs AutoAck
+	PowerUpDelay    time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Channel:         76,
+		DataRate:        DATA_RATE_1MBPS,
+		PALevel:         PA_LEVEL_MAX,
+		CRC:             CRC_16,
+		AddressWidth:    5,
+		AutoAck:         true,
+		RetransmitDelay: 1500 * time.Microsecond,
+		RetransmitCount: 15,
+		PayloadSize:     MAX_PAYLOAD_SIZE,
+		DynamicPayloads: false,
+		PowerUpDelay:    5 * time.Millisecond,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Channel < 0 || c.Channel > MAX_CHANNEL {
+		return errors.New("invalid channel")
+	}
+	if c.DataRate < DATA_RATE_1MBPS || c.DataRate > DATA_RATE_250KBPS {
+		return errors.New("invalid data rate")
+	}
+	if c.PALevel < PA_LEVEL_MIN || c.PALevel > PA_LEVEL_MAX {
+		return errors.New("invalid PA level")
+	}
+	if c.CRC < CRC_DISABLED || c.CRC > CRC_16 {
+		return errors.New("invalid CRC length")
+	}
+	if c.AutoAck && c.CRC == CRC_DISABLED {
+		return errors.New("auto acknowledgement needs the CRC")
+	}
+	if c.AddressWidth < 3 || c.AddressWidth > 5 {
+		return errors.New("invalid address width")
+	}
+	// the delay is set in steps of 250µs
+	if c.RetransmitDelay < 250*time.Microsecond || c.RetransmitDelay > 4000*time.Microsecond || c.RetransmitDelay%(250*time.Microsecond) != 0 {
+		return errors.New("invalid retransmit delay")
+	}
+	if c.RetransmitCount < 0 || c.RetransmitCount > 15 {
+		return errors.New("invalid retransmit count")
+	}
+	if c.PayloadSize < 1 || c.PayloadSize > MAX_PAYLOAD_SIZE {
+		return errors.New("invalid payload size")
+	}
+	if c.DynamicPayloads && !c.AutoAck {
+		return errors.New("dynamic payloads need auto acknowledgement")
+	}
+	if c.PowerUpDelay < 0 {
+		return errors.New("invalid power up delay")
+	}
+	return nil
+}
+
+// Status is the STATUS register.
+type Status struct {
+	DataReady  bool // a payload was received
+	DataSent   bool // a payload was sent and acknowledged
+	MaxRetries bool // a payload was not acknowledged
+	RxPipe     int  // pipe of the next payload of the RX FIFO, -1 when it is empty
+	TxFull     bool
+}
+
+func decodeStatus(s byte) Status {
+	pipe := int(s>>1) & 0x07
+	if pipe >= PIPES {
+		pipe = -1
+	}
+	return Status{
+		DataReady:  s&status_rx_dr != 0,
+		DataSent:   s&status_tx_ds != 0,
+		MaxRetries: s&status_max_rt != 0,
+		RxPipe:     pipe,
+		TxFull:     s&status_tx_ful != 0,
+	}
+}
+
+type device struct {
+	mu           sync.Mutex // protects the SPI bus, the buffers and the state
+	conn         spi.SPI
+	pinCE        gpio.GPIOPinOut
+	pinIRQ       gpio.GPIOPinIn // optional
+	config       Config
+	configReg    byte
+	enRxAddr     byte
+	txAddress    []byte
+	pipe0Address []byte // reading address of pipe 0, which the writing pipe also uses
+	listening    bool
+	txBuf        [1 + MAX_PAYLOAD_SIZE]byte
+	rxBuf        [1 + MAX_PAYLOAD_SIZE]byte
+}
+
+func NewNRF24L01(spiConn spi.SPI, pinCE gpio.GPIOPinOut, pinIRQ gpio.GPIOPinIn) (*device, error) {
+	return NewNRF24L01WithConfig(spiConn, pinCE, pinIRQ, DefaultConfig())
+}
+
+// NewNRF24L01WithConfig sets up the radio and powers it up in TX mode. pinIRQ
+// can be nil, the STATUS register is then polled.
+func NewNRF24L01WithConfig(spiConn spi.SPI, pinCE gpio.GPIOPinOut, pinIRQ gpio.GPIOPinIn, config Config) (*device, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	dev := &device{
+		conn:   spiConn,
+		pinCE:  pinCE,
+		pinIRQ: pinIRQ,
+		config: config,
+	}
+	dev.pinCE.Out(gpio.Low)
+	if err := dev.init(); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func (dev *device) init() error {
+	aw := byte(dev.config.AddressWidth - 2)
+	if err := dev.writeRegister(REG_SETUP_AW, aw); err != nil {
+		return err
+	}
+	// a missing radio reads as 0x00 or 0xFF
+	if v, err := dev.readRegister(REG_SETUP_AW); err != nil {
+		return err
+	} else if v != aw {
+		return errors.New("no response from the radio")
+	}
+	var aa, dynpd, feature byte
+	if dev.config.AutoAck {
+		aa = all_pipes
+	}
+	if dev.config.DynamicPayloads {
+		dynpd, feature = all_pipes, feature_en_dpl
+	}
+	retr := byte(dev.config.RetransmitDelay/(250*time.Microsecond)-1)<<4 | byte(dev.config.RetransmitCount)
+	dev.configReg = crcBits(dev.config.CRC)
+	type setting struct {
+		reg   byte
+		value byte
+	}
+	settings := []setting{
+		{REG_CONFIG, dev.configReg},
+		{REG_EN_AA, aa},
+		{REG_EN_RXADDR, 0},
+		{REG_SETUP_RETR, retr},
+		{REG_RF_CH, byte(dev.config.Channel)},
+		{REG_RF_SETUP, rfSetup(dev.config.DataRate, dev.config.PALevel)},
+		{REG_STATUS, status_rx_dr | status_tx_ds | status_max_rt},
+	}
+	for pipe := byte(0); pipe < PIPES; pipe++ {
+		settings = append(settings, setting{REG_RX_PW_P0 + pipe, byte(dev.config.PayloadSize)})
+	}
+	for _, r := range settings {
+		if err := dev.writeRegister(r.reg, r.value); err != nil {
+			return fmt.Errorf("register 0x%02X: %w", r.reg, err)
+		}
+	}
+	if err := dev.writeFeatures(feature, dynpd); err != nil {
+		return err
+	}
+	if err := dev.command(cmd_flush_tx); err != nil {
+		return err
+	}
+	if err := dev.command(cmd_flush_rx); err != nil {
+		return err
+	}
+	return dev.powerUp()
+}
+
+// writeFeatures writes FEATURE and DYNPD. The nRF24L01 without plus ignores
+// their writes until ACTIVATE, which toggles the lock, so it is only sent when
+// FEATURE did not take the value.
+func (dev *device) writeFeatures(feature, dynpd byte) error {
+	ok, err := dev.setFeatures(feature, dynpd)
+	if err != nil || ok {
+		return err
+	}
+	if _, _, err := dev.transfer(cmd_activate, []byte{activate_features}, 0); err != nil {
+		return err
+	}
+	if ok, err = dev.setFeatures(feature, dynpd); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("dynamic payloads are not supported by the radio")
+	}
+	return nil
+}
+
+// setFeatures writes FEATURE and DYNPD and tells if FEATURE kept its value.
+func (dev *device) setFeatures(feature, dynpd byte) (bool, error) {
+	if err := dev.writeRegister(REG_FEATURE, feature); err != nil {
+		return false, fmt.Errorf("register 0x%02X: %w", REG_FEATURE, err)
+	}
+	if err := dev.writeRegister(REG_DYNPD, dynpd); err != nil {
+		return false, fmt.Errorf("register 0x%02X: %w", REG_DYNPD, err)
+	}
+	v, err := dev.readRegister(REG_FEATURE)
+	if err != nil {
+		return false, err
+	}
+	return v&feature_en_dpl == feature&feature_en_dpl, nil
+}
+
+func crcBits(crc CRCLength) byte {
+	switch crc {
+	case CRC_8:
+		return config_en_crc
+	case CRC_16:
+		return config_en_crc | config_crco
+	}
+	return 0
+}
+
+func rfSetup(rate DataRate, level PALevel) byte {
+	bits := byte(level) << 1
+	switch rate {
+	case DATA_RATE_2MBPS:
+		bits |= rf_setup_dr_high
+	case DATA_RATE_250KBPS:
+		bits |= rf_setup_dr_low
+	}
+	return bits
+}
+
+// transfer sends a command with its data and returns the status and the n bytes
+// read after the command, which are valid until the next transfer.
+func (dev *device) transfer(cmd byte, data []byte, n int) (byte, []byte, error) {
+	size := 1 + len(data)
+	if 1+n > size {
+		size = 1 + n
+	}
+	w, r := dev.txBuf[:size], dev.rxBuf[:size]
+	w[0] = cmd
+	copy(w[1:], data)
+	for i := 1 + len(data); i < size; i++ {
+		w[i] = cmd_nop
+	}
+	if err := dev.conn.Tx(w, r); err != nil {
+		return 0, nil, err
+	}
+	return r[0], r[1 : 1+n], nil
+}
+
+func (dev *device) command(cmd byte) error {
+	_, _, err := dev.transfer(cmd, nil, 0)
+	return err
+}
+
+func (dev *device) readRegister(reg byte) (byte, error) {
+	_, value, err := dev.transfer(cmd_r_register|reg, nil, 1)
+	if err != nil {
+		return 0, err
+	}
+	return value[0], nil
+}
+
+func (dev *device) writeRegister(reg byte, value byte) error {
+	_, _, err := dev.transfer(cmd_w_register|reg, []byte{value}, 0)
+	return err
+}
+
+// ReadRegister reads a single byte register.
+func (dev *device) ReadRegister(reg byte) (byte, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.readRegister(reg)
+}
+
+// WriteRegister writes a single byte register, bypassing the state of the driver.
+func (dev *device) WriteRegister(reg byte, value byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.writeRegister(reg, value)
+}
+
+func (dev *device) status() (byte, error) {
+	s, _, err := dev.transfer(cmd_nop, nil, 0)
+	return s, err
+}
+
+func (dev *device) Status() (Status, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	s, err := dev.status()
+	if err != nil {
+		return Status{}, err
+	}
+	return decodeStatus(s), nil
+}
+
+func (dev *device) SetChannel(channel int) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if channel < 0 || channel > MAX_CHANNEL {
+		return errors.New("invalid channel")
+	}
+	if err := dev.writeRegister(REG_RF_CH, byte(channel)); err != nil {
+		return err
+	}
+	dev.config.Channel = channel
+	return nil
+}
+
+func (dev *device) SetDataRate(rate DataRate) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if rate < DATA_RATE_1MBPS || rate > DATA_RATE_250KBPS {
+		return errors.New("invalid data rate")
+	}
+	if err := dev.writeRegister(REG_RF_SETUP, rfSetup(rate, dev.config.PALevel)); err != nil {
+		return err
+	}
+	dev.config.DataRate = rate
+	return nil
+}
+
+func (dev *device) SetPALevel(level PALevel) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if level < PA_LEVEL_MIN || level > PA_LEVEL_MAX {
+		return errors.New("invalid PA level")
+	}
+	if err := dev.writeRegister(REG_RF_SETUP, rfSetup(dev.config.DataRate, level)); err != nil {
+		return err
+	}
+	dev.config.PALevel = level
+	return nil
+}
+
+func (dev *device) checkAddress(address []byte) error {
+	if len(address) != dev.config.AddressWidth {
+		return fmt.Errorf("address must be %d bytes", dev.config.AddressWidth)
+	}
+	return nil
+}
+
+// OpenWritingPipe sets the address of the sent packets, least significant byte
+// first. Pipe 0 receives the acknowledgements on the same address.
+func (dev *device) OpenWritingPipe(address []byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if err := dev.checkAddress(address); err != nil {
+		return err
+	}
+	if _, _, err := dev.transfer(cmd_w_register|REG_TX_ADDR, address, 0); err != nil {
+		return err
+	}
+	dev.txAddress = append([]byte{}, address...)
+	if dev.listening {
+		return nil
+	}
+	if _, _, err := dev.transfer(cmd_w_register|REG_RX_ADDR_P0, address, 0); err != nil {
+		return err
+	}
+	return dev.enablePipe(0, true)
+}
+
+// OpenReadingPipe receives the packets sent to address on pipe, from 0 to 5.
+// Pipes 2 to 5 only differ from pipe 1 by the least significant byte, the
+// first one of the address.
+func (dev *device) OpenReadingPipe(pipe int, address []byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if pipe < 0 || pipe >= PIPES {
+		return errors.New("invalid pipe")
+	}
+	if err := dev.checkAddress(address); err != nil {
+		return err
+	}
+	switch pipe {
+	case 0:
+		dev.pipe0Address = append([]byte{}, address...)
+		// the writing pipe uses pipe 0 until the radio listens
+		if dev.listening || dev.txAddress == nil {
+			if _, _, err := dev.transfer(cmd_w_register|REG_RX_ADDR_P0, address, 0); err != nil {
+				return err
+			}
+		}
+	case 1:
+		if _, _, err := dev.transfer(cmd_w_register|REG_RX_ADDR_P1, address, 0); err != nil {
+			return err
+		}
+	default:
+		_, p1, err := dev.transfer(cmd_r_register|REG_RX_ADDR_P1, nil, dev.config.AddressWidth)
+		if err != nil {
+			return err
+		}
+		for i := 1; i < len(address); i++ {
+			if address[i] != p1[i] {
+				return errors.New("address does not share the upper bytes of pipe 1")
+			}
+		}
+		if err := dev.writeRegister(REG_RX_ADDR_P0+byte(pipe), address[0]); err != nil {
+			return err
+		}
+	}
+	return dev.enablePipe(pipe, true)
+}
+
+// ClosePipe stops receiving on pipe.
+func (dev *device) ClosePipe(pipe int) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if pipe < 0 || pipe >= PIPES {
+		return errors.New("invalid pipe")
+	}
+	if pipe == 0 {
+		dev.pipe0Address = nil
+	}
+	return dev.enablePipe(pipe, false)
+}
+
+func (dev *device) enablePipe(pipe int, enabled bool) error {
+	v := dev.enRxAddr &^ (1 << pipe)
+	if enabled {
+		v |= 1 << pipe
+	}
+	if err := dev.writeRegister(REG_EN_RXADDR, v); err != nil {
+		return err
+	}
+	dev.enRxAddr = v
+	return nil
+}
+
+// StartListening switches to RX mode and receives on the open reading pipes.
+func (dev *device) StartListening() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.pipe0Address != nil {
+		if _, _, err := dev.transfer(cmd_w_register|REG_RX_ADDR_P0, dev.pipe0Address, 0); err != nil {
+			return err
+		}
+	} else if dev.enRxAddr&1 != 0 {
+		// pipe 0 is only open for the acknowledgements of the writing pipe
+		if err := dev.enablePipe(0, false); err != nil {
+			return err
+		}
+	}
+	if err := dev.setConfig(dev.configReg | config_prim_rx); err != nil {
+		return err
+	}
+	if err := dev.writeRegister(REG_STATUS, status_rx_dr|status_tx_ds|status_max_rt); err != nil {
+		return err
+	}
+	dev.pinCE.Out(gpio.High)
+	dev.listening = true
+	return nil
+}
+
+// StopListening switches back to TX mode.
+func (dev *device) StopListening() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.pinCE.Out(gpio.Low)
+	dev.listening = false
+	if err := dev.setConfig(dev.configReg &^ config_prim_rx); err != nil {
+		return err
+	}
+	if dev.txAddress == nil {
+		return nil
+	}
+	if _, _, err := dev.transfer(cmd_w_register|REG_RX_ADDR_P0, dev.txAddress, 0); err != nil {
+		return err
+	}
+	return dev.enablePipe(0, true)
+}
+
+func (dev *device) setConfig(v byte) error {
+	if err := dev.writeRegister(REG_CONFIG, v); err != nil {
+		return err
+	}
+	dev.configReg = v
+	return nil
+}
+
+// Send sends a payload and waits for its acknowledgement. Static payloads
+// shorter than Config.PayloadSize are padded with zeros.
+func (dev *device) Send(payload []byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	size := MAX_PAYLOAD_SIZE
+	if !dev.config.DynamicPayloads {
+		size = dev.config.PayloadSize
+	}
+	if len(payload) == 0 || len(payload) > size {
+		return errors.New("invalid payload size")
+	}
+	if dev.listening {
+		return errors.New("cannot send while listening")
+	}
+	data := payload
+	if !dev.config.DynamicPayloads {
+		data = make([]byte, size)
+		copy(data, payload)
+	}
+	if _, _, err := dev.transfer(cmd_w_tx_payload, data, 0); err != nil {
+		return err
+	}
+	dev.pinCE.Out(gpio.High)
+	time.Sleep(ce_pulse)
+	dev.pinCE.Out(gpio.Low)
+
+	s, err := dev.waitSent()
+	if err != nil {
+		return err
+	}
+	if err := dev.writeRegister(REG_STATUS, status_tx_ds|status_max_rt); err != nil {
+		return err
+	}
+	if s&status_max_rt != 0 {
+		// the packet stays in the TX FIFO after the last retransmission
+		if err := dev.command(cmd_flush_tx); err != nil {
+			return err
+		}
+		return ErrMaxRetries
+	}
+	return nil
+}
+
+// waitSent waits for TX_DS or MAX_RT and returns the status.
+func (dev *device) waitSent() (byte, error) {
+	deadline := time.Now().Add(send_timeout)
+	for {
+		// the IRQ pin saves the SPI transfers while the radio is busy
+		if dev.pinIRQ == nil || dev.pinIRQ.Read() == gpio.Low {
+			s, err := dev.status()
+			if err != nil {
+				return 0, err
+			}
+			if s&(status_tx_ds|status_max_rt) != 0 {
+				return s, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if err := dev.command(cmd_flush_tx); err != nil {
+				return 0, errors.Join(ErrSendTimeout, fmt.Errorf("flush TX FIFO: %w", err))
+			}
+			return 0, ErrSendTimeout
+		}
+		time.Sleep(send_poll_delay)
+	}
+}
+
+// Available tells if a payload is waiting in the RX FIFO.
+func (dev *device) Available() (bool, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	s, err := dev.status()
+	if err != nil {
+		return false, err
+	}
+	return decodeStatus(s).RxPipe >= 0, nil
+}
+
+// Receive reads the next payload into buf and returns its size, truncated to
+// the size of buf, and its pipe. It returns ErrNoPayload when the RX FIFO is empty.
+func (dev *device) Receive(buf []byte) (n int, pipe int, err error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	s, err := dev.status()
+	if err != nil {
+		return 0, -1, err
+	}
+	pipe = decodeStatus(s).RxPipe
+	if pipe < 0 {
+		return 0, -1, ErrNoPayload
+	}
+	size := dev.config.PayloadSize
+	if dev.config.DynamicPayloads {
+		_, width, err := dev.transfer(cmd_r_rx_pl_wid, nil, 1)
+		if err != nil {
+			return 0, -1, err
+		}
+		size = int(width[0])
+		// a corrupted width must be flushed
+		if size < 1 || size > MAX_PAYLOAD_SIZE {
+			if err := dev.command(cmd_flush_rx); err != nil {
+				return 0, -1, err
+			}
+			return 0, -1, errors.New("invalid payload width")
+		}
+	}
+	_, payload, err := dev.transfer(cmd_r_rx_payload, nil, size)
+	if err != nil {
+		return 0, -1, err
+	}
+	n = copy(buf, payload)
+	if err := dev.writeRegister(REG_STATUS, status_rx_dr); err != nil {
+		return 0, -1, err
+	}
+	return n, pipe, nil
+}
+
+// ObserveTx returns the packets lost since the channel was set, up to 15, and
+// the retransmissions of the last packet.
+func (dev *device) ObserveTx() (lost, retransmits int, err error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	v, err := dev.readRegister(REG_OBSERVE_TX)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 4), int(v & 0x0F), nil
+}
+
+func (dev *device) FlushTx() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.command(cmd_flush_tx)
+}
+
+func (dev *device) FlushRx() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.command(cmd_flush_rx)
+}
+
+// PowerDown stops the radio, the registers are kept.
+func (dev *device) PowerDown() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.pinCE.Out(gpio.Low)
+	dev.listening = false
+	return dev.setConfig(dev.configReg &^ (config_pwr_up | config_prim_rx))
+}
+
+// PowerUp powers the radio up in TX mode.
+func (dev *device) PowerUp() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.powerUp()
+}
+
+func (dev *device) powerUp() error {
+	if err := dev.setConfig(dev.configReg | config_pwr_up); err != nil {
+		return err
+	}
+	time.Sleep(dev.config.PowerUpDelay)
+	return nil
+}
@@ -0,0 +1,478 @@
+package nrf24l01
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+	"github.com/marksaravi/devices-go/hardware/nrf24l01/nrf24l01test"
+	"github.com/marksaravi/devices-go/hardware/spi/spitest"
+)
+
+var (
+	txAddress = []byte{0xE1, 0xF0, 0xF0, 0xF0, 0xF0}
+	rxAddress = []byte{0xD2, 0xF0, 0xF0, 0xF0, 0xF0}
+)
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.PowerUpDelay = 0
+	return config
+}
+
+func newTestRadio(t *testing.T, config Config) (*device, *nrf24l01test.Radio, *spitest.SPI) {
+	t.Helper()
+	radio := nrf24l01test.NewRadio()
+	conn := spitest.NewSPI(nil, radio)
+	dev, err := NewNRF24L01WithConfig(conn, radio.CE(), radio.IRQ(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, radio, conn
+}
+
+// newLink connects a sender to a receiver listening to txAddress on pipe 1.
+func newLink(t *testing.T, config Config) (sender, receiver *device, senderRadio *nrf24l01test.Radio) {
+	t.Helper()
+	sender, senderRadio, _ = newTestRadio(t, config)
+	receiver, receiverRadio, _ := newTestRadio(t, config)
+	nrf24l01test.Connect(senderRadio, receiverRadio)
+	if err := sender.OpenWritingPipe(txAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.OpenReadingPipe(1, txAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.StartListening(); err != nil {
+		t.Fatal(err)
+	}
+	return sender, receiver, senderRadio
+}
+
+func TestNewNRF24L01(t *testing.T) {
+	config := testConfig()
+	config.Channel = 100
+	config.DataRate = DATA_RATE_250KBPS
+	config.PALevel = PA_LEVEL_LOW
+	config.AddressWidth = 4
+	config.RetransmitDelay = 500 * time.Microsecond
+	config.RetransmitCount = 5
+	config.PayloadSize = 8
+	_, radio, _ := newTestRadio(t, config)
+	tests := []struct {
+		reg  byte
+		want byte
+	}{
+		{REG_CONFIG, 0x0E},
+		{REG_EN_AA, 0x3F},
+		{REG_EN_RXADDR, 0x00},
+		{REG_SETUP_AW, 0x02},
+		{REG_SETUP_RETR, 0x15},
+		{REG_RF_CH, 100},
+		{REG_RF_SETUP, 0x22},
+		{REG_STATUS, 0x0E},
+		{REG_RX_PW_P0 + 5, 8},
+		{REG_FIFO_STATUS, 0x11},
+		{REG_DYNPD, 0x00},
+		{REG_FEATURE, 0x00},
+	}
+	for _, test := range tests {
+		if got := radio.Register(test.reg); got != test.want {
+			t.Errorf("register 0x%02X: wanted 0x%02X, got 0x%02X", test.reg, test.want, got)
+		}
+	}
+
+	conn := spitest.NewSPI(nil, nil)
+	if _, err := NewNRF24L01WithConfig(conn, radio.CE(), nil, testConfig()); err == nil {
+		t.Errorf("wanted error without a radio")
+	}
+	bad := testConfig()
+	bad.DynamicPayloads, bad.AutoAck = true, false
+	if _, err := NewNRF24L01WithConfig(conn, radio.CE(), nil, bad); err == nil {
+		t.Errorf("wanted error for dynamic payloads without auto acknowledgement")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"channel", func(c *Config) { c.Channel = MAX_CHANNEL + 1 }},
+		{"data rate", func(c *Config) { c.DataRate = 3 }},
+		{"PA level", func(c *Config) { c.PALevel = -1 }},
+		{"auto ack without CRC", func(c *Config) { c.CRC = CRC_DISABLED }},
+		{"address width", func(c *Config) { c.AddressWidth = 6 }},
+		{"retransmit delay", func(c *Config) { c.RetransmitDelay = 300 * time.Microsecond }},
+		{"retransmit count", func(c *Config) { c.RetransmitCount = 16 }},
+		{"payload size", func(c *Config) { c.PayloadSize = 0 }},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		test.modify(&config)
+		if err := config.validate(); err == nil {
+			t.Errorf("%s: wanted error", test.name)
+		}
+	}
+	if err := testConfig().validate(); err != nil {
+		t.Errorf("wanted the default config valid, got %v", err)
+	}
+}
+
+func TestRegisterAccess(t *testing.T) {
+	dev, radio, conn := newTestRadio(t, testConfig())
+	conn.Reset()
+	if err := dev.WriteRegister(REG_RF_CH, 0x4C); err != nil {
+		t.Fatal(err)
+	}
+	v, err := dev.ReadRegister(REG_RF_CH)
+	if err != nil || v != 0x4C {
+		t.Errorf("wanted 0x4C, got 0x%02X, %v", v, err)
+	}
+	if err := dev.SetPALevel(PA_LEVEL_MIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SetDataRate(DATA_RATE_2MBPS); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.SetChannel(MAX_CHANNEL + 1); err == nil {
+		t.Errorf("wanted error for invalid channel")
+	}
+	want := [][]byte{
+		{0x25, 0x4C},
+		{0x05, 0xFF},
+		{0x26, 0x00},
+		{0x26, 0x08},
+	}
+	transactions := conn.Transactions()
+	if len(transactions) != len(want) {
+		t.Fatalf("wanted %d transactions, got %d", len(want), len(transactions))
+	}
+	for i, tr := range transactions {
+		if !bytes.Equal(tr.W, want[i]) {
+			t.Errorf("transaction %d: wanted % X, got % X", i, want[i], tr.W)
+		}
+	}
+	if got := radio.Register(REG_RF_SETUP); got != 0x08 {
+		t.Errorf("wanted RF_SETUP 0x08, got 0x%02X", got)
+	}
+
+	s, err := dev.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != (Status{RxPipe: -1}) {
+		t.Errorf("wanted an empty status, got %+v", s)
+	}
+}
+
+func TestPipes(t *testing.T) {
+	dev, radio, _ := newTestRadio(t, testConfig())
+	if err := dev.OpenWritingPipe(txAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.OpenReadingPipe(0, rxAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.OpenReadingPipe(2, []byte{0xC3, 0xC2, 0xC2, 0xC2, 0xC2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := radio.Address(REG_RX_ADDR_P0); !bytes.Equal(got, txAddress) {
+		t.Errorf("wanted pipe 0 on the writing address until listening, got % X", got)
+	}
+	if err := dev.StartListening(); err != nil {
+		t.Fatal(err)
+	}
+	if got := radio.Address(REG_RX_ADDR_P0); !bytes.Equal(got, rxAddress) {
+		t.Errorf("wanted pipe 0 on the reading address while listening, got % X", got)
+	}
+	if got := radio.Register(REG_EN_RXADDR); got != 0x05 {
+		t.Errorf("wanted pipes 0 and 2 enabled, got 0x%02X", got)
+	}
+	if !radio.Listening() {
+		t.Errorf("wanted the radio listening")
+	}
+	if err := dev.Send([]byte{1}); err == nil {
+		t.Errorf("wanted error for sending while listening")
+	}
+	if err := dev.StopListening(); err != nil {
+		t.Fatal(err)
+	}
+	if got := radio.Address(REG_RX_ADDR_P0); !bytes.Equal(got, txAddress) {
+		t.Errorf("wanted pipe 0 back on the writing address, got % X", got)
+	}
+
+	tests := []struct {
+		name    string
+		pipe    int
+		address []byte
+	}{
+		{"invalid pipe", PIPES, rxAddress},
+		{"short address", 1, rxAddress[:4]},
+		{"upper bytes", 3, []byte{0xC4, 0xF0, 0xC2, 0xC2, 0xC2}},
+	}
+	for _, test := range tests {
+		if err := dev.OpenReadingPipe(test.pipe, test.address); err == nil {
+			t.Errorf("%s: wanted error", test.name)
+		}
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	config := testConfig()
+	config.PayloadSize = 4
+	sender, receiver, _ := newLink(t, config)
+	if ok, err := receiver.Available(); err != nil || ok {
+		t.Errorf("wanted nothing available, got %v, %v", ok, err)
+	}
+	buf := make([]byte, MAX_PAYLOAD_SIZE)
+	if _, _, err := receiver.Receive(buf); err != ErrNoPayload {
+		t.Errorf("wanted %v, got %v", ErrNoPayload, err)
+	}
+	for _, payload := range [][]byte{{1, 2, 3, 4}, {5, 6}} {
+		if err := sender.Send(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Send([]byte{1, 2, 3, 4, 5}); err == nil {
+		t.Errorf("wanted error for a payload longer than the payload size")
+	}
+	if ok, err := receiver.Available(); err != nil || !ok {
+		t.Errorf("wanted a payload available, got %v, %v", ok, err)
+	}
+	for _, want := range [][]byte{{1, 2, 3, 4}, {5, 6, 0, 0}} {
+		n, pipe, err := receiver.Receive(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pipe != 1 || !bytes.Equal(buf[:n], want) {
+			t.Errorf("wanted % X on pipe 1, got % X on pipe %d", want, buf[:n], pipe)
+		}
+	}
+	if s, _ := sender.Status(); s.DataSent {
+		t.Errorf("wanted TX_DS cleared after sending")
+	}
+	if lost, retransmits, err := sender.ObserveTx(); err != nil || lost != 0 || retransmits != 0 {
+		t.Errorf("wanted no lost packets and retransmissions, got %d, %d, %v", lost, retransmits, err)
+	}
+}
+
+func TestDynamicPayloads(t *testing.T) {
+	config := testConfig()
+	config.DynamicPayloads = true
+	sender, receiver, _ := newLink(t, config)
+	payloads := [][]byte{{1}, bytes.Repeat([]byte{7}, MAX_PAYLOAD_SIZE), {2, 3, 4}}
+	for _, payload := range payloads {
+		if err := sender.Send(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, MAX_PAYLOAD_SIZE)
+	for _, want := range payloads {
+		n, _, err := receiver.Receive(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("wanted % X, got % X", want, buf[:n])
+		}
+	}
+	if err := sender.Send(make([]byte, MAX_PAYLOAD_SIZE+1)); err == nil {
+		t.Errorf("wanted error for a payload longer than %d bytes", MAX_PAYLOAD_SIZE)
+	}
+}
+
+// activations counts the ACTIVATE commands.
+func activations(conn *spitest.SPI) int {
+	n := 0
+	for _, tr := range conn.Transactions() {
+		if len(tr.W) > 0 && tr.W[0] == cmd_activate {
+			n++
+		}
+	}
+	return n
+}
+
+// activateFailure ignores ACTIVATE, as a radio without the feature registers.
+type activateFailure struct {
+	*nrf24l01test.Radio
+}
+
+func (f activateFailure) Tx(w, r []byte) error {
+	if len(w) > 0 && w[0] == cmd_activate {
+		return nil
+	}
+	return f.Radio.Tx(w, r)
+}
+
+func TestActivate(t *testing.T) {
+	config := testConfig()
+	config.DynamicPayloads = true
+	legacy := nrf24l01test.NewRadio()
+	legacy.SetLegacy()
+	tests := []struct {
+		name        string
+		radio       *nrf24l01test.Radio
+		config      Config
+		activations int
+	}{
+		{"plus", nrf24l01test.NewRadio(), config, 0},
+		{"legacy, static payloads", legacy, testConfig(), 0},
+		{"legacy", legacy, config, 1},
+		// ACTIVATE toggles, the radio stays activated on a new init
+		{"legacy, activated", legacy, config, 0},
+	}
+	for _, test := range tests {
+		conn := spitest.NewSPI(nil, test.radio)
+		if _, err := NewNRF24L01WithConfig(conn, test.radio.CE(), nil, test.config); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if n := activations(conn); n != test.activations {
+			t.Errorf("%s: wanted %d ACTIVATE, got %d", test.name, test.activations, n)
+		}
+		want := byte(0)
+		if test.config.DynamicPayloads {
+			want = feature_en_dpl
+		}
+		if got := test.radio.Register(REG_FEATURE); got != want {
+			t.Errorf("%s: wanted FEATURE 0x%02X, got 0x%02X", test.name, want, got)
+		}
+	}
+
+	locked := nrf24l01test.NewRadio()
+	locked.SetLegacy()
+	conn := spitest.NewSPI(nil, activateFailure{locked})
+	if _, err := NewNRF24L01WithConfig(conn, locked.CE(), nil, config); err == nil {
+		t.Errorf("wanted error when FEATURE stays locked")
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	config := testConfig()
+	config.RetransmitCount = 10
+	sender, receiver, senderRadio := newLink(t, config)
+	if err := receiver.StopListening(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sender.Send([]byte{1}); err != ErrMaxRetries {
+			t.Errorf("wanted %v, got %v", ErrMaxRetries, err)
+		}
+	}
+	lost, retransmits, err := sender.ObserveTx()
+	if err != nil || lost != 2 || retransmits != 10 {
+		t.Errorf("wanted 2 lost packets and 10 retransmissions, got %d, %d, %v", lost, retransmits, err)
+	}
+	if got := senderRadio.Register(REG_FIFO_STATUS); got&0x10 == 0 {
+		t.Errorf("wanted the TX FIFO flushed, got FIFO_STATUS 0x%02X", got)
+	}
+	if senderRadio.IRQ().Read() != gpio.High {
+		t.Errorf("wanted the interrupts cleared")
+	}
+	if err := sender.SetChannel(10); err != nil {
+		t.Fatal(err)
+	}
+	if lost, _, _ := sender.ObserveTx(); lost != 0 {
+		t.Errorf("wanted the lost packets reset on a new channel, got %d", lost)
+	}
+
+	// the receiver listens on the old channel
+	if err := receiver.StartListening(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send([]byte{1}); err != ErrMaxRetries {
+		t.Errorf("wanted %v on another channel, got %v", ErrMaxRetries, err)
+	}
+}
+
+func TestSendWithoutIRQ(t *testing.T) {
+	radio := nrf24l01test.NewRadio()
+	dev, err := NewNRF24L01WithConfig(radio, radio.CE(), nil, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.OpenWritingPipe(txAddress); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Send([]byte{1}); err != nil {
+		t.Errorf("wanted the packet acknowledged, got %v", err)
+	}
+	radio.SetAck(false)
+	if err := dev.Send([]byte{1}); err != ErrMaxRetries {
+		t.Errorf("wanted %v, got %v", ErrMaxRetries, err)
+	}
+	sent := radio.Sent()
+	if len(sent) != 2 || !bytes.Equal(sent[0].Address, txAddress) || len(sent[0].Payload) != MAX_PAYLOAD_SIZE {
+		t.Errorf("wanted 2 packets of %d bytes to % X, got %v", MAX_PAYLOAD_SIZE, txAddress, sent)
+	}
+}
+
+func TestPowerDown(t *testing.T) {
+	dev, radio, _ := newTestRadio(t, testConfig())
+	if err := dev.StartListening(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.PowerDown(); err != nil {
+		t.Fatal(err)
+	}
+	if got := radio.Register(REG_CONFIG); got != 0x0C || radio.Listening() {
+		t.Errorf("wanted CONFIG 0x0C and not listening, got 0x%02X", got)
+	}
+	if err := dev.PowerUp(); err != nil {
+		t.Fatal(err)
+	}
+	if got := radio.Register(REG_CONFIG); got != 0x0E {
+		t.Errorf("wanted CONFIG 0x0E, got 0x%02X", got)
+	}
+}
+
+func TestBusError(t *testing.T) {
+	dev, radio, conn := newTestRadio(t, testConfig())
+	failure := errors.New("bus failure")
+	if err := dev.OpenWritingPipe(txAddress); err != nil {
+		t.Fatal(err)
+	}
+	conn.FailAfter(1, failure)
+	if err := dev.Send([]byte{1}); !errors.Is(err, failure) {
+		t.Errorf("wanted %v, got %v", failure, err)
+	}
+	conn.FailAfter(0, nil)
+	conn = spitest.NewSPI(nil, radio)
+	conn.FailAfter(3, failure)
+	if _, err := NewNRF24L01WithConfig(conn, radio.CE(), nil, testConfig()); !errors.Is(err, failure) {
+		t.Errorf("wanted %v, got %v", failure, err)
+	}
+}
+
+// flushFailure fails the FLUSH_TX command.
+type flushFailure struct {
+	*nrf24l01test.Radio
+	err error
+}
+
+func (f flushFailure) Tx(w, r []byte) error {
+	if len(w) > 0 && w[0] == cmd_flush_tx {
+		return f.err
+	}
+	return f.Radio.Tx(w, r)
+}
+
+func TestSendTimeout(t *testing.T) {
+	radio := nrf24l01test.NewRadio()
+	failure := errors.New("bus failure")
+	// the radio never sends without its CE pin
+	dev, err := NewNRF24L01WithConfig(radio, gpiotest.NewPin(gpio.Low), nil, testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Send([]byte{1}); err != ErrSendTimeout {
+		t.Errorf("wanted %v, got %v", ErrSendTimeout, err)
+	}
+	dev.conn = flushFailure{radio, failure}
+	if err := dev.Send([]byte{1}); !errors.Is(err, ErrSendTimeout) || !errors.Is(err, failure) {
+		t.Errorf("wanted %v with %v, got %v", ErrSendTimeout, failure, err)
+	}
+}
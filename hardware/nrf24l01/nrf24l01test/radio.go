@@ -0,0 +1,424 @@
+// Package nrf24l01test provides a virtual NRF24L01 which answers the SPI commands
+// at the register level and exchanges packets with other virtual radios.
+package nrf24l01test
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+const (
+	reg_config      byte = 0x00
+	reg_en_aa       byte = 0x01
+	reg_en_rxaddr   byte = 0x02
+	reg_setup_aw    byte = 0x03
+	reg_setup_retr  byte = 0x04
+	reg_rf_ch       byte = 0x05
+	reg_rf_setup    byte = 0x06
+	reg_status      byte = 0x07
+	reg_observe_tx  byte = 0x08
+	reg_rx_addr_p0  byte = 0x0A
+	reg_rx_addr_p1  byte = 0x0B
+	reg_tx_addr     byte = 0x10
+	reg_rx_pw_p0    byte = 0x11
+	reg_fifo_status byte = 0x17
+	reg_dynpd       byte = 0x1C
+	reg_feature     byte = 0x1D
+	num_registers        = 0x1E
+
+	cmd_r_register         byte = 0x00
+	cmd_w_register         byte = 0x20
+	cmd_activate           byte = 0x50
+	cmd_r_rx_pl_wid        byte = 0x60
+	cmd_r_rx_payload       byte = 0x61
+	cmd_w_tx_payload       byte = 0xA0
+	cmd_w_tx_payload_noack byte = 0xB0
+	cmd_flush_tx           byte = 0xE1
+	cmd_flush_rx           byte = 0xE2
+	cmd_nop                byte = 0xFF
+
+	config_pwr_up  byte = 1 << 1
+	config_prim_rx byte = 1 << 0
+	status_rx_dr   byte = 1 << 6
+	status_tx_ds   byte = 1 << 5
+	status_max_rt  byte = 1 << 4
+	status_irqs    byte = status_rx_dr | status_tx_ds | status_max_rt
+	feature_en_dpl byte = 1 << 2
+	rf_setup_rate  byte = 1<<5 | 1<<3
+
+	activate_features byte = 0x73
+
+	fifo_size    = 3
+	max_payload  = 32
+	address_size = 5
+)
+
+// Packet is a payload sent to an address.
+type Packet struct {
+	Address []byte
+	Payload []byte
+}
+
+type rxPacket struct {
+	pipe    int
+	payload []byte
+}
+
+type txPacket struct {
+	payload []byte
+	noAck   bool
+}
+
+// Radio is a virtual NRF24L01 connected to the SPI bus. It implements spi.SPI,
+// every transfer is a command with its data while CSN is low. CE and IRQ are the
+// pins to give to the driver. A rising edge of CE in TX mode sends a packet.
+// Without a connected radio, sent packets are acknowledged unless SetAck(false).
+type Radio struct {
+	mu        sync.Mutex
+	regs      [num_registers]byte
+	addresses map[byte][]byte // RX_ADDR_P0, RX_ADDR_P1 and TX_ADDR
+	rxFIFO    []rxPacket
+	txFIFO    []txPacket
+	ce        gpio.Level
+	peer      *Radio
+	ack       bool
+	sent      []Packet
+	legacy    bool // an nRF24L01 without plus, FEATURE and DYNPD need ACTIVATE
+	activated bool
+}
+
+func NewRadio() *Radio {
+	r := &Radio{ack: true}
+	r.reset()
+	return r
+}
+
+// reset sets the registers to their power on values.
+func (r *Radio) reset() {
+	r.regs = [num_registers]byte{}
+	r.regs[reg_config] = 0x08
+	r.regs[reg_en_aa] = 0x3F
+	r.regs[reg_en_rxaddr] = 0x03
+	r.regs[reg_setup_aw] = 0x03
+	r.regs[reg_setup_retr] = 0x03
+	r.regs[reg_rf_ch] = 0x02
+	r.regs[reg_rf_setup] = 0x0E
+	for i, lsb := range []byte{0xC3, 0xC4, 0xC5, 0xC6} {
+		r.regs[reg_rx_addr_p1+1+byte(i)] = lsb
+	}
+	r.addresses = map[byte][]byte{
+		reg_rx_addr_p0: bytes.Repeat([]byte{0xE7}, address_size),
+		reg_rx_addr_p1: bytes.Repeat([]byte{0xC2}, address_size),
+		reg_tx_addr:    bytes.Repeat([]byte{0xE7}, address_size),
+	}
+	r.rxFIFO = nil
+	r.txFIFO = nil
+}
+
+// Connect puts two radios on the air, each receives the packets of the other.
+func Connect(a, b *Radio) {
+	a.mu.Lock()
+	a.peer = b
+	a.mu.Unlock()
+	b.mu.Lock()
+	b.peer = a
+	b.mu.Unlock()
+}
+
+// SetAck sets if the packets sent without a connected radio are acknowledged.
+func (r *Radio) SetAck(ack bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ack = ack
+}
+
+func (r *Radio) Tx(w, rd []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rd == nil {
+		rd = make([]byte, len(w))
+	}
+	for i := range rd {
+		rd[i] = 0
+	}
+	if len(w) == 0 {
+		return nil
+	}
+	rd[0] = r.status()
+	cmd, data, out := w[0], w[1:], rd[1:]
+	switch {
+	case cmd == cmd_activate:
+		if r.legacy && len(data) > 0 && data[0] == activate_features {
+			r.activated = !r.activated
+			r.regs[reg_feature], r.regs[reg_dynpd] = 0, 0
+		}
+	case cmd < cmd_w_register:
+		reg := cmd & 0x1F
+		if address, ok := r.addresses[reg]; ok {
+			copy(out, address)
+		} else if int(reg) < num_registers && len(out) > 0 {
+			out[0] = r.register(reg)
+		}
+	case cmd < cmd_r_rx_pl_wid:
+		r.writeRegister(cmd&0x1F, data)
+	case cmd == cmd_r_rx_pl_wid:
+		if len(r.rxFIFO) > 0 && len(out) > 0 {
+			out[0] = byte(len(r.rxFIFO[0].payload))
+		}
+	case cmd == cmd_r_rx_payload:
+		if len(r.rxFIFO) > 0 {
+			copy(out, r.rxFIFO[0].payload)
+			r.rxFIFO = r.rxFIFO[1:]
+		}
+	case cmd == cmd_w_tx_payload || cmd == cmd_w_tx_payload_noack:
+		if len(r.txFIFO) < fifo_size && len(data) > 0 && len(data) <= max_payload {
+			r.txFIFO = append(r.txFIFO, txPacket{payload: append([]byte{}, data...), noAck: cmd == cmd_w_tx_payload_noack})
+		}
+	case cmd == cmd_flush_tx:
+		r.txFIFO = nil
+	case cmd == cmd_flush_rx:
+		r.rxFIFO = nil
+	}
+	return nil
+}
+
+// status is the STATUS register with the pipe of the next received payload.
+func (r *Radio) status() byte {
+	s := r.regs[reg_status]&status_irqs | 0x07<<1
+	if len(r.rxFIFO) > 0 {
+		s = s&^(0x07<<1) | byte(r.rxFIFO[0].pipe)<<1
+	}
+	if len(r.txFIFO) == fifo_size {
+		s |= 1
+	}
+	return s
+}
+
+func (r *Radio) register(reg byte) byte {
+	switch reg {
+	case reg_status:
+		return r.status()
+	case reg_fifo_status:
+		var s byte
+		if len(r.rxFIFO) == 0 {
+			s |= 1 << 0
+		}
+		if len(r.rxFIFO) == fifo_size {
+			s |= 1 << 1
+		}
+		if len(r.txFIFO) == 0 {
+			s |= 1 << 4
+		}
+		if len(r.txFIFO) == fifo_size {
+			s |= 1 << 5
+		}
+		return s
+	}
+	return r.regs[reg]
+}
+
+func (r *Radio) writeRegister(reg byte, data []byte) {
+	if len(data) == 0 || int(reg) >= num_registers {
+		return
+	}
+	if address, ok := r.addresses[reg]; ok {
+		copy(address, data)
+		return
+	}
+	if r.legacy && !r.activated && (reg == reg_feature || reg == reg_dynpd) {
+		return
+	}
+	switch reg {
+	case reg_status:
+		// writing 1 clears an interrupt
+		r.regs[reg_status] &^= data[0] & status_irqs
+	case reg_observe_tx, reg_fifo_status:
+	case reg_rf_ch:
+		r.regs[reg] = data[0] & 0x7F
+		// the lost packets count restarts on a new channel
+		r.regs[reg_observe_tx] &= 0x0F
+	default:
+		r.regs[reg] = data[0]
+	}
+}
+
+// SetLegacy makes the radio an nRF24L01 without plus, which ignores the writes
+// of FEATURE and DYNPD until ACTIVATE. Each ACTIVATE toggles the lock.
+func (r *Radio) SetLegacy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.legacy = true
+}
+
+// Register returns the value of a single byte register.
+func (r *Radio) Register(reg byte) byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.register(reg)
+}
+
+// Address returns the address of RX_ADDR_P0, RX_ADDR_P1 or TX_ADDR, least
+// significant byte first.
+func (r *Radio) Address(reg byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte{}, r.addresses[reg]...)
+}
+
+// Sent returns the packets sent on the air, acknowledged or not.
+func (r *Radio) Sent() []Packet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent := make([]Packet, len(r.sent))
+	copy(sent, r.sent)
+	return sent
+}
+
+// Listening tells if the radio is powered up in RX mode with CE high.
+func (r *Radio) Listening() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.listening()
+}
+
+func (r *Radio) listening() bool {
+	return r.regs[reg_config]&config_pwr_up != 0 && r.regs[reg_config]&config_prim_rx != 0 && r.ce == gpio.High
+}
+
+// CE returns the chip enable pin of the radio.
+func (r *Radio) CE() gpio.GPIOPinOut {
+	return cePin{r}
+}
+
+// IRQ returns the interrupt pin of the radio, low while an unmasked interrupt is set.
+func (r *Radio) IRQ() gpio.GPIOPinIn {
+	return irqPin{r}
+}
+
+type cePin struct {
+	r *Radio
+}
+
+func (p cePin) Out(level gpio.Level) {
+	r := p.r
+	r.mu.Lock()
+	rising := level == gpio.High && r.ce == gpio.Low
+	r.ce = level
+	transmitting := rising && r.regs[reg_config]&config_pwr_up != 0 && r.regs[reg_config]&config_prim_rx == 0 && len(r.txFIFO) > 0
+	if !transmitting {
+		r.mu.Unlock()
+		return
+	}
+	packet := r.txFIFO[0]
+	address := append([]byte{}, r.addresses[reg_tx_addr][:r.addressWidth()]...)
+	channel, rate := r.regs[reg_rf_ch], r.regs[reg_rf_setup]&rf_setup_rate
+	r.sent = append(r.sent, Packet{Address: address, Payload: packet.payload})
+	peer, acked := r.peer, r.ack
+	// the acknowledgement comes back on pipe 0, which must listen to TX_ADDR
+	listensAck := r.regs[reg_en_rxaddr]&1 != 0 && bytes.Equal(r.addresses[reg_rx_addr_p0][:r.addressWidth()], address)
+	r.mu.Unlock()
+
+	// the peer is locked after the radio is unlocked, two radios can send at once
+	if peer != nil {
+		acked = peer.receive(address, channel, rate, packet.payload)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if packet.noAck || r.regs[reg_en_aa]&1 == 0 || acked && listensAck {
+		r.txFIFO = r.txFIFO[1:]
+		r.regs[reg_status] |= status_tx_ds
+		r.regs[reg_observe_tx] &= 0xF0
+		return
+	}
+	// the packet stays in the FIFO after the last retransmission
+	lost := r.regs[reg_observe_tx] >> 4
+	if lost < 0x0F {
+		lost++
+	}
+	r.regs[reg_observe_tx] = lost<<4 | r.regs[reg_setup_retr]&0x0F
+	r.regs[reg_status] |= status_max_rt
+}
+
+// Deliver receives a packet from the air as if it was sent to the address, it
+// returns false if the radio does not listen to it.
+func (r *Radio) Deliver(address, payload []byte) bool {
+	r.mu.Lock()
+	channel, rate := r.regs[reg_rf_ch], r.regs[reg_rf_setup]&rf_setup_rate
+	r.mu.Unlock()
+	return r.receive(address, channel, rate, payload)
+}
+
+func (r *Radio) receive(address []byte, channel, rate byte, payload []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.listening() || channel != r.regs[reg_rf_ch] || rate != r.regs[reg_rf_setup]&rf_setup_rate {
+		return false
+	}
+	pipe := r.pipe(address)
+	if pipe < 0 || len(r.rxFIFO) == fifo_size {
+		return false
+	}
+	if r.regs[reg_feature]&feature_en_dpl == 0 || r.regs[reg_dynpd]&(1<<pipe) == 0 {
+		width := int(r.regs[reg_rx_pw_p0+byte(pipe)])
+		if width == 0 {
+			return false
+		}
+		static := make([]byte, width)
+		copy(static, payload)
+		payload = static
+	}
+	r.rxFIFO = append(r.rxFIFO, rxPacket{pipe: pipe, payload: append([]byte{}, payload...)})
+	r.regs[reg_status] |= status_rx_dr
+	return true
+}
+
+// pipe returns the enabled pipe with the address or -1.
+func (r *Radio) pipe(address []byte) int {
+	width := r.addressWidth()
+	if len(address) < width {
+		return -1
+	}
+	address = address[:width]
+	for pipe := 0; pipe < 6; pipe++ {
+		if r.regs[reg_en_rxaddr]&(1<<pipe) == 0 {
+			continue
+		}
+		var pipeAddress []byte
+		switch pipe {
+		case 0:
+			pipeAddress = r.addresses[reg_rx_addr_p0][:width]
+		case 1:
+			pipeAddress = r.addresses[reg_rx_addr_p1][:width]
+		default:
+			// pipes 2 to 5 share the upper bytes of pipe 1
+			pipeAddress = append([]byte{r.regs[reg_rx_addr_p0+byte(pipe)]}, r.addresses[reg_rx_addr_p1][1:width]...)
+		}
+		if bytes.Equal(address, pipeAddress) {
+			return pipe
+		}
+	}
+	return -1
+}
+
+func (r *Radio) addressWidth() int {
+	width := int(r.regs[reg_setup_aw]&0x03) + 2
+	if width < 3 {
+		width = 3
+	}
+	return width
+}
+
+type irqPin struct {
+	r *Radio
+}
+
+func (p irqPin) Read() gpio.Level {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+	// CONFIG masks the interrupts with the same bits as STATUS
+	pending := p.r.regs[reg_status] & status_irqs &^ p.r.regs[reg_config]
+	return pending == 0
+}
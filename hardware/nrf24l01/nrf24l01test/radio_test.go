@@ -0,0 +1,112 @@
+package nrf24l01test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+)
+
+func TestRegisters(t *testing.T) {
+	r := NewRadio()
+	rd := make([]byte, 2)
+	r.Tx([]byte{cmd_r_register | reg_rf_ch, cmd_nop}, rd)
+	if rd[0] != 0x0E || rd[1] != 0x02 {
+		t.Errorf("wanted status 0x0E and RF_CH 0x02, got % X", rd)
+	}
+	r.Tx([]byte{cmd_w_register | reg_rf_ch, 0x4C}, nil)
+	if got := r.Register(reg_rf_ch); got != 0x4C {
+		t.Errorf("wanted 0x4C, got 0x%02X", got)
+	}
+	r.Tx([]byte{cmd_w_register | reg_tx_addr, 1, 2, 3, 4, 5}, nil)
+	rd = make([]byte, 6)
+	r.Tx([]byte{cmd_r_register | reg_tx_addr, 0, 0, 0, 0, 0}, rd)
+	if !bytes.Equal(rd[1:], []byte{1, 2, 3, 4, 5}) || !bytes.Equal(r.Address(reg_tx_addr), rd[1:]) {
+		t.Errorf("wanted TX_ADDR 01 02 03 04 05, got % X", rd[1:])
+	}
+	if got := r.Register(reg_fifo_status); got != 0x11 {
+		t.Errorf("wanted empty FIFOs 0x11, got 0x%02X", got)
+	}
+	for i := 0; i < 4; i++ {
+		r.Tx([]byte{cmd_w_tx_payload, byte(i)}, nil)
+	}
+	if got := r.Register(reg_fifo_status); got != 0x21 {
+		t.Errorf("wanted full TX FIFO 0x21, got 0x%02X", got)
+	}
+	r.Tx([]byte{cmd_flush_tx}, nil)
+	if got := r.Register(reg_fifo_status); got != 0x11 {
+		t.Errorf("wanted empty FIFOs after flush 0x11, got 0x%02X", got)
+	}
+}
+
+func TestActivate(t *testing.T) {
+	r := NewRadio()
+	r.SetLegacy()
+	steps := []struct {
+		activate bool
+		want     byte
+	}{
+		{false, 0x00},
+		{true, feature_en_dpl},
+		{true, 0x00},
+	}
+	for i, step := range steps {
+		if step.activate {
+			r.Tx([]byte{cmd_activate, activate_features}, nil)
+		}
+		r.Tx([]byte{cmd_w_register | reg_feature, feature_en_dpl}, nil)
+		if got := r.Register(reg_feature); got != step.want {
+			t.Errorf("step %d: wanted FEATURE 0x%02X, got 0x%02X", i, step.want, got)
+		}
+	}
+
+	plus := NewRadio()
+	plus.Tx([]byte{cmd_activate, activate_features}, nil)
+	plus.Tx([]byte{cmd_w_register | reg_feature, feature_en_dpl}, nil)
+	if got := plus.Register(reg_feature); got != feature_en_dpl {
+		t.Errorf("wanted ACTIVATE ignored without SetLegacy, got FEATURE 0x%02X", got)
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	tx, rx := NewRadio(), NewRadio()
+	Connect(tx, rx)
+	address := []byte{1, 2, 3, 4, 5}
+	tx.Tx([]byte{cmd_w_register | reg_config, 0x0A}, nil)
+	tx.Tx(append([]byte{cmd_w_register | reg_tx_addr}, address...), nil)
+	tx.Tx(append([]byte{cmd_w_register | reg_rx_addr_p0}, address...), nil)
+	rx.Tx([]byte{cmd_w_register | reg_config, 0x0B}, nil)
+	rx.Tx(append([]byte{cmd_w_register | reg_rx_addr_p1}, address...), nil)
+	rx.Tx([]byte{cmd_w_register | reg_rx_pw_p0 + 1, 2}, nil)
+	rx.CE().Out(gpio.High)
+
+	tx.Tx([]byte{cmd_w_tx_payload, 0xAB}, nil)
+	tx.CE().Out(gpio.High)
+	tx.CE().Out(gpio.Low)
+	if got := tx.Register(reg_status); got&status_tx_ds == 0 || tx.IRQ().Read() != gpio.Low {
+		t.Errorf("wanted TX_DS and IRQ low, got status 0x%02X", got)
+	}
+	if got := rx.Register(reg_status); got != status_rx_dr|1<<1 {
+		t.Errorf("wanted RX_DR on pipe 1, got status 0x%02X", got)
+	}
+	rd := make([]byte, 3)
+	rx.Tx([]byte{cmd_r_rx_payload, cmd_nop, cmd_nop}, rd)
+	if !bytes.Equal(rd[1:], []byte{0xAB, 0}) {
+		t.Errorf("wanted the payload padded to 2 bytes, got % X", rd[1:])
+	}
+
+	rx.CE().Out(gpio.Low)
+	tx.Tx([]byte{cmd_w_register | reg_status, status_tx_ds}, nil)
+	tx.Tx([]byte{cmd_w_tx_payload, 0xCD}, nil)
+	tx.CE().Out(gpio.High)
+	tx.CE().Out(gpio.Low)
+	if got := tx.Register(reg_status); got&status_max_rt == 0 {
+		t.Errorf("wanted MAX_RT without a listening radio, got status 0x%02X", got)
+	}
+	if got := tx.Register(reg_observe_tx); got != 0x13 {
+		t.Errorf("wanted 1 lost packet and 3 retransmissions, got 0x%02X", got)
+	}
+	if got := len(tx.Sent()); got != 2 {
+		t.Errorf("wanted 2 sent packets, got %d", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Package spitest provides a recording fake SPI connection for testing drivers without hardware.
+package spitest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+// Transaction is a single recorded Tx call.
+type Transaction struct {
+	DC  gpio.Level // level of the data/command pin during the transfer
+	W   []byte
+	R   []byte
+	Err error // error returned by Tx
+}
+
+// Command is a command byte followed by the data bytes sent after it.
+type Command struct {
+	Cmd  byte
+	Data []byte
+}
+
+// SPI is a fake spi.SPI that records every transaction.
+// If a device is attached, transfers are forwarded to it so it can fill the read buffer.
+type SPI struct {
+	mu           sync.Mutex
+	dc           gpio.GPIOPinIn
+	device       spi.SPI
+	transactions []Transaction
+	failAfter    int
+	failErr      error
+	latency      time.Duration
+}
+
+// NewSPI creates a fake SPI connection. Both dc and device are optional;
+// without a dc pin every transaction is recorded with DC Low.
+func NewSPI(dc gpio.GPIOPinIn, device spi.SPI) *SPI {
+	return &SPI{
+		dc:           dc,
+		device:       device,
+		transactions: make([]Transaction, 0),
+	}
+}
+
+func (s *SPI) Tx(w, r []byte) error {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := Transaction{
+		DC: gpio.Low,
+		W:  append([]byte{}, w...),
+	}
+	if s.dc != nil {
+		t.DC = s.dc.Read()
+	}
+	if s.failErr != nil && s.failAfter == 0 {
+		t.Err = s.failErr
+		s.transactions = append(s.transactions, t)
+		return t.Err
+	}
+	s.failAfter--
+	if s.device != nil {
+		t.Err = s.device.Tx(w, r)
+	}
+	if r != nil {
+		t.R = append([]byte{}, r...)
+	}
+	s.transactions = append(s.transactions, t)
+	return t.Err
+}
+
+// FailAfter makes every transaction after the next n ones fail with err.
+// Failed transactions are recorded but not forwarded to the device.
+// A nil err stops the failures.
+func (s *SPI) FailAfter(n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failAfter = n
+	s.failErr = err
+}
+
+// SetLatency makes every transaction take at least d, like a slow bus.
+func (s *SPI) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Transactions returns the recorded transactions in order.
+func (s *SPI) Transactions() []Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transactions := make([]Transaction, len(s.transactions))
+	copy(transactions, s.transactions)
+	return transactions
+}
+
+// Commands decodes the recorded transactions, see Commands.
+func (s *SPI) Commands() []Command {
+	return Commands(s.Transactions())
+}
+
+// Reset clears the recorded transactions.
+func (s *SPI) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transactions = s.transactions[:0]
+}
+
+// Commands decodes a transaction log into commands. Every byte sent with DC Low
+// starts a new command and bytes sent with DC High are appended to the data of
+// the last command. Data sent before the first command is ignored.
+func Commands(transactions []Transaction) []Command {
+	commands := make([]Command, 0)
+	for _, t := range transactions {
+		if t.DC == gpio.Low {
+			for _, cmd := range t.W {
+				commands = append(commands, Command{Cmd: cmd, Data: make([]byte, 0)})
+			}
+			continue
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		last := &commands[len(commands)-1]
+		last.Data = append(last.Data, t.W...)
+	}
+	return commands
+}
@@ -0,0 +1,83 @@
+package spitest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+)
+
+type echoDevice struct{}
+
+func (echoDevice) Tx(w, r []byte) error {
+	copy(r, w)
+	return nil
+}
+
+func TestCommands(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.High)
+	conn := NewSPI(dc, echoDevice{})
+	conn.Tx([]byte{0x01}, nil)
+	dc.Out(gpio.Low)
+	conn.Tx([]byte{0x2A}, nil)
+	dc.Out(gpio.High)
+	conn.Tx([]byte{0x00, 0x10}, nil)
+	r := make([]byte, 1)
+	conn.Tx([]byte{0x20}, r)
+	dc.Out(gpio.Low)
+	conn.Tx([]byte{0x2C, 0x29}, nil)
+
+	if r[0] != 0x20 {
+		t.Errorf("wanted the attached device to fill the read buffer, got %x", r[0])
+	}
+	transactions := conn.Transactions()
+	if len(transactions) != 5 {
+		t.Fatalf("wanted 5 transactions, got %d", len(transactions))
+	}
+	if transactions[0].DC != gpio.High || transactions[1].DC != gpio.Low {
+		t.Errorf("DC levels are not recorded")
+	}
+	if !bytes.Equal(transactions[3].R, []byte{0x20}) {
+		t.Errorf("read buffer is not recorded, got %x", transactions[3].R)
+	}
+
+	want := []Command{
+		{0x2A, []byte{0x00, 0x10, 0x20}},
+		{0x2C, []byte{}},
+		{0x29, []byte{}},
+	}
+	got := conn.Commands()
+	if len(got) != len(want) {
+		t.Fatalf("wanted %d commands, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Cmd != want[i].Cmd || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("at %d, wanted %x %x, got %x %x", i, want[i].Cmd, want[i].Data, got[i].Cmd, got[i].Data)
+		}
+	}
+
+	conn.Reset()
+	if len(conn.Transactions()) != 0 {
+		t.Errorf("transactions are not cleared")
+	}
+}
+
+func TestFailAfter(t *testing.T) {
+	conn := NewSPI(nil, nil)
+	errTx := errors.New("tx failed")
+	conn.FailAfter(2, errTx)
+	for i, want := range []error{nil, nil, errTx, errTx} {
+		if err := conn.Tx([]byte{byte(i)}, nil); err != want {
+			t.Errorf("at %d, wanted %v, got %v", i, want, err)
+		}
+	}
+	conn.FailAfter(0, nil)
+	if err := conn.Tx([]byte{0}, nil); err != nil {
+		t.Errorf("wanted no error, got %v", err)
+	}
+	if transactions := conn.Transactions(); len(transactions) != 5 || transactions[3].Err != errTx {
+		t.Errorf("failed transactions are not recorded")
+	}
+}
@@ -0,0 +1,275 @@
+// Package ssd1306 drives SSD1306 monochrome OLED displays over I2C or SPI.
+// The display memory is a 1 bit per pixel framebuffer kept in pages of 8 rows.
+package ssd1306
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/i2c"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+// DEFAULT_ADDRESS is the I2C address of most modules, 0x3D if the address pin is high.
+const DEFAULT_ADDRESS uint16 = 0x3C
+
+const page_height = 8
+
+type Rotation int
+
+// ROTATION_180 turns the picture upside down with the segment remap and the
+// COM scan direction of the controller.
+const (
+	ROTATION_0   Rotation = 0
+	ROTATION_180 Rotation = 1
+)
+
+// Config describes the module. Threshold is the luminance, 1 to 255, from which
+// a colour lights the pixel up.
+type Config struct {
+	Width       int
+	Height      int // 64 or 32
+	Address     uint16
+	ChunkSize   int // maximum number of display data bytes in a single transfer
+	ResetDelay  time.Duration
+	ExternalVCC bool // the panel voltage comes from the module instead of the charge pump
+	Contrast    byte
+	Threshold   byte
+	Rotation    Rotation
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Width:      128,
+		Height:     64,
+		Address:    DEFAULT_ADDRESS,
+		ChunkSize:  1024,
+		ResetDelay: 10 * time.Millisecond,
+		Contrast:   0xCF,
+		Threshold:  128,
+		Rotation:   ROTATION_0,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Width <= 0 || c.Width > 128 || (c.Height != 64 && c.Height != 32) {
+		return errors.New("invalid screen size")
+	}
+	if c.ChunkSize <= 0 {
+		return errors.New("invalid chunk size")
+	}
+	if c.ResetDelay < 0 {
+		return errors.New("invalid reset delay")
+	}
+	if c.Threshold == 0 {
+		return errors.New("invalid threshold")
+	}
+	if c.Rotation != ROTATION_0 && c.Rotation != ROTATION_180 {
+		return errors.New("invalid rotation")
+	}
+	return nil
+}
+
+type device struct {
+	mu            sync.Mutex
+	transport     transport
+	config        Config
+	pages         int
+	buffer        []byte // page by page, a byte is a column of 8 rows with the top row in bit 0
+	isPageChanged []bool
+}
+
+// NewSSD1306I2C creates the driver of a module at config.Address on the bus.
+func NewSSD1306I2C(bus i2c.I2C, config Config) (*device, error) {
+	return newSSD1306(&i2cTransport{bus: bus, config: config}, config)
+}
+
+func NewSSD1306SPI(
+	spiConn spi.SPI,
+	pinDC gpio.GPIOPinOut,
+	pinRST gpio.GPIOPinOut,
+	config Config,
+) (*device, error) {
+	return newSSD1306(&spiTransport{conn: spiConn, pinDC: pinDC, pinRST: pinRST, config: config}, config)
+}
+
+func newSSD1306(t transport, config Config) (*device, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	dev := &device{
+		transport:     t,
+		config:        config,
+		pages:         config.Height / page_height,
+		buffer:        make([]byte, config.Width*config.Height/page_height),
+		isPageChanged: make([]bool, config.Height/page_height),
+	}
+	// the content of the display memory is unknown after reset
+	for page := range dev.isPageChanged {
+		dev.isPageChanged[page] = true
+	}
+	if err := dev.init(); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func (dev *device) init() error {
+	dev.transport.reset()
+	chargePump, precharge, comPins := byte(0x14), byte(0xF1), byte(0x12)
+	if dev.config.ExternalVCC {
+		chargePump, precharge = 0x10, 0x22
+	}
+	if dev.config.Height == 32 {
+		comPins = 0x02
+	}
+	segmentRemap, comScan := byte(0xA1), byte(0xC8)
+	if dev.config.Rotation == ROTATION_180 {
+		segmentRemap, comScan = 0xA0, 0xC0
+	}
+	return dev.transport.command(
+		0xAE,       // Display off
+		0xD5, 0x80, // Clock divide ratio and oscillator frequency
+		0xA8, byte(dev.config.Height-1), // Multiplex ratio
+		0xD3, 0x00, // Display offset
+		0x40,             // Start line 0
+		0x8D, chargePump, // Charge pump
+		0x20, 0x00, // Horizontal addressing mode
+		segmentRemap,
+		comScan,
+		0xDA, comPins, // COM pins hardware configuration
+		0x81, dev.config.Contrast,
+		0xD9, precharge, // Pre-charge period
+		0xDB, 0x40, // VCOMH deselect level
+		0xA4, // Display follows the memory
+		0xA6, // Normal display
+		0x2E, // Deactivate scroll
+		0xAF, // Display on
+	)
+}
+
+// Pixel lights the pixel up if the luminance of the colour reaches Config.Threshold.
+func (dev *device) Pixel(x, y int, color colors.Color) {
+	on := dev.isOn(color)
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.pixel(x, y, on)
+}
+
+// isOn thresholds the luminance of a colour, colours which are not RGB888 are off.
+func (dev *device) isOn(color colors.Color) bool {
+	c, err := colors.ToRGB888(color)
+	if err != nil {
+		return false
+	}
+	r, g, b := int(c>>16&0xFF), int(c>>8&0xFF), int(c&0xFF)
+	return (299*r+587*g+114*b)/1000 >= int(dev.config.Threshold)
+}
+
+func (dev *device) pixel(x, y int, on bool) {
+	if x < 0 || y < 0 || x >= dev.config.Width || y >= dev.config.Height {
+		return
+	}
+	page := y / page_height
+	i := page*dev.config.Width + x
+	bit := byte(1) << (y % page_height)
+	old := dev.buffer[i]
+	if on {
+		dev.buffer[i] |= bit
+	} else {
+		dev.buffer[i] &^= bit
+	}
+	if dev.buffer[i] != old {
+		dev.isPageChanged[page] = true
+	}
+}
+
+func (dev *device) ScreenWidth() int {
+	return dev.config.Width
+}
+
+func (dev *device) ScreenHeight() int {
+	return dev.config.Height
+}
+
+func (dev *device) Update() int {
+	counter, _ := dev.UpdateErr()
+	return counter
+}
+
+// UpdateErr sends the changed pages and returns their number. Consecutive
+// changed pages are sent in a single window. It stops at the first failed
+// transfer and the pages which are not sent stay changed.
+func (dev *device) UpdateErr() (int, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	counter := 0
+	for first := 0; first < dev.pages; first++ {
+		if !dev.isPageChanged[first] {
+			continue
+		}
+		last := first
+		for last+1 < dev.pages && dev.isPageChanged[last+1] {
+			last++
+		}
+		if err := dev.sendPages(first, last); err != nil {
+			return counter, fmt.Errorf("page %d: %w", first, err)
+		}
+		for page := first; page <= last; page++ {
+			dev.isPageChanged[page] = false
+		}
+		counter += last - first + 1
+		first = last
+	}
+	return counter, nil
+}
+
+func (dev *device) sendPages(first, last int) error {
+	err := dev.transport.command(
+		0x21, 0x00, byte(dev.config.Width-1), // Column address
+		0x22, byte(first), byte(last), // Page address
+	)
+	if err != nil {
+		return err
+	}
+	return dev.transport.data(dev.buffer[first*dev.config.Width : (last+1)*dev.config.Width])
+}
+
+// SetContrast sets the brightness, 0 to 255.
+func (dev *device) SetContrast(contrast byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if err := dev.transport.command(0x81, contrast); err != nil {
+		return err
+	}
+	dev.config.Contrast = contrast
+	return nil
+}
+
+// DisplayOn wakes the panel up, the memory keeps its content while it is off.
+func (dev *device) DisplayOn() error {
+	return dev.command(0xAF)
+}
+
+// DisplayOff turns the panel off and puts the controller to sleep.
+func (dev *device) DisplayOff() error {
+	return dev.command(0xAE)
+}
+
+// SetInversion lights up the pixels which are off and turns off the others.
+func (dev *device) SetInversion(on bool) error {
+	if on {
+		return dev.command(0xA7)
+	}
+	return dev.command(0xA6)
+}
+
+func (dev *device) command(cmd byte) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.transport.command(cmd)
+}
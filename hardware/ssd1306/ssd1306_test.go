@@ -0,0 +1,248 @@
+package ssd1306
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/devices/display"
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+	"github.com/marksaravi/devices-go/hardware/i2c/i2ctest"
+	"github.com/marksaravi/devices-go/hardware/spi/spitest"
+	"github.com/marksaravi/fonts-go/fonts"
+)
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.ResetDelay = 0
+	return config
+}
+
+func newTestDevice(t *testing.T, config Config) (*device, *i2ctest.I2C) {
+	t.Helper()
+	bus := i2ctest.NewI2C(nil)
+	dev, err := NewSSD1306I2C(bus, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Update()
+	bus.Reset()
+	return dev, bus
+}
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		height      int
+		externalVCC bool
+		rotation    Rotation
+		want        []byte
+	}{
+		{64, false, ROTATION_0, []byte{0x00, 0xAE, 0xD5, 0x80, 0xA8, 0x3F, 0xD3, 0x00, 0x40, 0x8D, 0x14, 0x20, 0x00, 0xA1, 0xC8,
+			0xDA, 0x12, 0x81, 0xCF, 0xD9, 0xF1, 0xDB, 0x40, 0xA4, 0xA6, 0x2E, 0xAF}},
+		{32, true, ROTATION_180, []byte{0x00, 0xAE, 0xD5, 0x80, 0xA8, 0x1F, 0xD3, 0x00, 0x40, 0x8D, 0x10, 0x20, 0x00, 0xA0, 0xC0,
+			0xDA, 0x02, 0x81, 0xCF, 0xD9, 0x22, 0xDB, 0x40, 0xA4, 0xA6, 0x2E, 0xAF}},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.Height = test.height
+		config.ExternalVCC = test.externalVCC
+		config.Rotation = test.rotation
+		bus := i2ctest.NewI2C(nil)
+		if _, err := NewSSD1306I2C(bus, config); err != nil {
+			t.Fatal(err)
+		}
+		got := bus.Transactions()[0]
+		if got.Addr != DEFAULT_ADDRESS || !bytes.Equal(got.W, test.want) {
+			t.Errorf("height %d: wanted %x %x, got %x %x", test.height, DEFAULT_ADDRESS, test.want, got.Addr, got.W)
+		}
+	}
+
+	config := testConfig()
+	config.Height = 48
+	if _, err := NewSSD1306I2C(i2ctest.NewI2C(nil), config); err == nil {
+		t.Errorf("wanted error for invalid height")
+	}
+	config = testConfig()
+	config.Threshold = 0
+	if _, err := NewSSD1306I2C(i2ctest.NewI2C(nil), config); err == nil {
+		t.Errorf("wanted error for invalid threshold")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	bus := i2ctest.NewI2C(nil)
+	config := testConfig()
+	config.ChunkSize = 256
+	dev, err := NewSSD1306I2C(bus, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Reset()
+	if n := dev.Update(); n != 8 {
+		t.Errorf("wanted all 8 pages after init, got %d", n)
+	}
+	transactions := bus.Transactions()
+	if len(transactions) != 5 || !bytes.Equal(transactions[0].W, []byte{0x00, 0x21, 0x00, 0x7F, 0x22, 0x00, 0x07}) {
+		t.Fatalf("wanted a window of the whole screen and 4 chunks, got %d transactions", len(transactions))
+	}
+	for _, tr := range transactions[1:] {
+		if len(tr.W) != 257 || tr.W[0] != 0x40 {
+			t.Errorf("wanted chunks of 256 data bytes, got %d bytes starting with %x", len(tr.W), tr.W[0])
+		}
+	}
+
+	bus.Reset()
+	dev.Pixel(3, 10, colors.WHITE)
+	dev.Pixel(4, 23, colors.WHITE)
+	dev.Pixel(5, 63, colors.WHITE)
+	if n := dev.Update(); n != 3 {
+		t.Errorf("wanted 3 pages, got %d", n)
+	}
+	transactions = bus.Transactions()
+	if len(transactions) != 4 {
+		t.Fatalf("wanted 2 windows, got %d transactions", len(transactions))
+	}
+	if !bytes.Equal(transactions[0].W, []byte{0x00, 0x21, 0x00, 0x7F, 0x22, 0x01, 0x02}) ||
+		!bytes.Equal(transactions[2].W, []byte{0x00, 0x21, 0x00, 0x7F, 0x22, 0x07, 0x07}) {
+		t.Errorf("wanted windows of pages 1-2 and 7, got %x and %x", transactions[0].W, transactions[2].W)
+	}
+	pages := transactions[1].W[1:]
+	if len(pages) != 256 || pages[3] != 0x04 || pages[128+4] != 0x80 {
+		t.Errorf("wrong data of pages 1-2")
+	}
+	if data := transactions[3].W[1:]; data[5] != 0x80 {
+		t.Errorf("wanted bit 7 of column 5 on page 7, got %x", data[5])
+	}
+
+	dev.Pixel(3, 10, colors.WHITE)
+	if n := dev.Update(); n != 0 {
+		t.Errorf("wanted no pages for an unchanged pixel, got %d", n)
+	}
+	dev.Pixel(3, 10, colors.BLACK)
+	dev.Pixel(-1, 10, colors.WHITE)
+	dev.Pixel(128, 64, colors.WHITE)
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted 1 page, got %d", n)
+	}
+}
+
+func TestUpdateError(t *testing.T) {
+	dev, bus := newTestDevice(t, testConfig())
+	dev.Pixel(0, 0, colors.WHITE)
+	dev.Pixel(0, 40, colors.WHITE)
+	errBus := errors.New("nack")
+	bus.FailAfter(2, errBus)
+	n, err := dev.UpdateErr()
+	if n != 1 || !errors.Is(err, errBus) {
+		t.Errorf("wanted 1 page and %v, got %d and %v", errBus, n, err)
+	}
+	bus.FailAfter(0, nil)
+	if n := dev.Update(); n != 1 {
+		t.Errorf("wanted the failed page to be sent again, got %d", n)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	tests := []struct {
+		color colors.Color
+		on    bool
+	}{
+		{colors.WHITE, true},
+		{colors.BLACK, false},
+		{colors.RGB888(0x808080), true},
+		{colors.RGB888(0x7F7F7F), false},
+		{colors.RGB888(0x0000FF), false},
+		{colors.YELLOW, true},
+		{colors.RGB565(0xFFFF), false},
+	}
+	dev, _ := newTestDevice(t, testConfig())
+	for _, test := range tests {
+		if on := dev.isOn(test.color); on != test.on {
+			t.Errorf("%v: wanted %v, got %v", test.color, test.on, on)
+		}
+	}
+}
+
+func TestRGBDisplay(t *testing.T) {
+	draw := func(d display.RGBDisplay) {
+		d.SetBackgroundColor(colors.BLACK)
+		d.Clear()
+		d.SetColor(colors.WHITE)
+		d.Rectangle(0, 0, 127, 63)
+		d.FillCircle(100, 32, 20)
+		d.SetColor(colors.NAVY)
+		d.FillCircle(100, 32, 10)
+		d.SetColor(colors.WHITE)
+		d.SetFont(fonts.FreeMono9pt7b)
+		d.MoveCursor(4, 20)
+		d.Write("OLED 42")
+	}
+	dev, _ := newTestDevice(t, testConfig())
+	draw(display.NewRGBDisplay(dev))
+	want := display.NewImageDevice(128, 64)
+	draw(display.NewRGBDisplay(want))
+	img := want.Image()
+	lit := 0
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 128; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			on := dev.isOn(colors.RGB888((r>>8)<<16 | (g>>8)<<8 | b>>8))
+			if on {
+				lit++
+			}
+			if got := dev.buffer[y/8*128+x]&(1<<(y%8)) != 0; got != on {
+				t.Fatalf("at %d,%d, wanted %v, got %v", x, y, on, got)
+			}
+		}
+	}
+	if lit == 0 {
+		t.Errorf("wanted some pixels on")
+	}
+}
+
+func TestSPI(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.Low)
+	rst := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	config := testConfig()
+	config.ChunkSize = 100
+	dev, err := NewSSD1306SPI(conn, dc, rst, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rst.History(); len(got) != 3 || got[1] != gpio.Low || got[2] != gpio.High {
+		t.Errorf("wanted a reset pulse, got %v", got)
+	}
+	transactions := conn.Transactions()
+	if len(transactions) != 1 || transactions[0].DC != gpio.Low || transactions[0].W[0] != 0xAE {
+		t.Fatalf("wanted the init commands with DC low")
+	}
+	conn.Reset()
+	dev.Update()
+	transactions = conn.Transactions()
+	if len(transactions) != 12 {
+		t.Fatalf("wanted a window and 11 chunks, got %d transactions", len(transactions))
+	}
+	if transactions[0].DC != gpio.Low || !bytes.Equal(transactions[0].W, []byte{0x21, 0x00, 0x7F, 0x22, 0x00, 0x07}) {
+		t.Errorf("wanted the window with DC low, got %x", transactions[0].W)
+	}
+	for _, tr := range transactions[1:] {
+		if tr.DC != gpio.High {
+			t.Errorf("wanted the data with DC high")
+		}
+	}
+
+	conn.Reset()
+	dev.SetInversion(true)
+	dev.SetContrast(0x10)
+	dev.DisplayOff()
+	cmds := []byte{}
+	for _, tr := range conn.Transactions() {
+		cmds = append(cmds, tr.W...)
+	}
+	if !bytes.Equal(cmds, []byte{0xA7, 0x81, 0x10, 0xAE}) {
+		t.Errorf("wanted A7 81 10 AE, got %x", cmds)
+	}
+}
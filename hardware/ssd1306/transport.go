@@ -0,0 +1,88 @@
+package ssd1306
+
+import (
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/i2c"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+const (
+	i2c_control_command byte = 0x00
+	i2c_control_data    byte = 0x40
+)
+
+// transport sends commands and display data over I2C or SPI.
+type transport interface {
+	command(cmds ...byte) error
+	data(data []byte) error
+	reset()
+}
+
+type i2cTransport struct {
+	bus    i2c.I2C
+	config Config
+	buf    []byte
+}
+
+// command sends the commands with their parameters after a single control byte.
+func (t *i2cTransport) command(cmds ...byte) error {
+	t.buf = append(append(t.buf[:0], i2c_control_command), cmds...)
+	return t.bus.Tx(t.config.Address, t.buf, nil)
+}
+
+// data sends the display data in chunks, each after a control byte.
+func (t *i2cTransport) data(data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > t.config.ChunkSize {
+			n = t.config.ChunkSize
+		}
+		t.buf = append(append(t.buf[:0], i2c_control_data), data[:n]...)
+		if err := t.bus.Tx(t.config.Address, t.buf, nil); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// reset does nothing, the I2C modules reset themselves at power up.
+func (t *i2cTransport) reset() {}
+
+type spiTransport struct {
+	conn   spi.SPI
+	pinDC  gpio.GPIOPinOut
+	pinRST gpio.GPIOPinOut
+	config Config
+}
+
+func (t *spiTransport) command(cmds ...byte) error {
+	t.pinDC.Out(gpio.Low)
+	return t.conn.Tx(cmds, nil)
+}
+
+func (t *spiTransport) data(data []byte) error {
+	t.pinDC.Out(gpio.High)
+	for len(data) > 0 {
+		n := len(data)
+		if n > t.config.ChunkSize {
+			n = t.config.ChunkSize
+		}
+		if err := t.conn.Tx(data[:n], nil); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (t *spiTransport) reset() {
+	t.pinRST.Out(gpio.High)
+	time.Sleep(t.config.ResetDelay)
+	t.pinRST.Out(gpio.Low)
+	time.Sleep(t.config.ResetDelay)
+	t.pinRST.Out(gpio.High)
+	time.Sleep(t.config.ResetDelay)
+}
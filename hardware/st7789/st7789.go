@@ -0,0 +1,79 @@
+// Package st7789 drives ST7789 SPI TFT modules. The ST7789 understands the
+// drawing commands of the ILI9341, so the driver shares its segment-buffer pipeline.
+package st7789
+
+import (
+	"errors"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/ili9341"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+type PanelSize int
+
+// PanelSize selects a common ST7789 module. The controller memory is 240 columns
+// by 320 rows, the smaller panels show a part of it.
+const (
+	PANEL_240X240 PanelSize = 0
+	PANEL_240X320 PanelSize = 1
+	PANEL_135X240 PanelSize = 2
+)
+
+const (
+	memory_columns = 240
+	memory_rows    = 320
+)
+
+// DefaultConfig returns the config of a module. Width and Height are for
+// ili9341.ROTATION_0, the landscape orientation of the 240x320 and 135x240 panels.
+func DefaultConfig(size PanelSize) (ili9341.Config, error) {
+	config := ili9341.DefaultConfig()
+	config.Controller = ili9341.CONTROLLER_ST7789
+	config.ColorOrder = ili9341.COLOR_ORDER_RGB
+	config.MemoryColumns = memory_columns
+	config.MemoryRows = memory_rows
+	switch size {
+	case PANEL_240X240:
+		config.Width, config.Height = 240, 240
+		config.SegmentWidth, config.SegmentHeight = 24, 24
+	case PANEL_240X320:
+		config.Width, config.Height = 320, 240
+		config.SegmentWidth, config.SegmentHeight = 32, 24
+	case PANEL_135X240:
+		// the panel is in the middle of the memory
+		config.Width, config.Height = 240, 135
+		config.SegmentWidth, config.SegmentHeight = 24, 27
+		config.ColumnOffset, config.RowOffset = 52, 40
+	default:
+		return ili9341.Config{}, errors.New("invalid panel size")
+	}
+	return config, nil
+}
+
+func NewST7789(
+	spiConn spi.SPI,
+	pinDC gpio.GPIOPinOut,
+	pinRST gpio.GPIOPinOut,
+	size PanelSize,
+	rotation ili9341.Rotation,
+) (*ili9341.Device, error) {
+	config, err := DefaultConfig(size)
+	if err != nil {
+		return nil, err
+	}
+	config.Rotation = rotation
+	return NewST7789WithConfig(spiConn, pinDC, pinRST, config)
+}
+
+// NewST7789WithConfig creates the ILI9341 driver set up for an ST7789 with a
+// config from DefaultConfig, possibly changed. The controller is always CONTROLLER_ST7789.
+func NewST7789WithConfig(
+	spiConn spi.SPI,
+	pinDC gpio.GPIOPinOut,
+	pinRST gpio.GPIOPinOut,
+	config ili9341.Config,
+) (*ili9341.Device, error) {
+	config.Controller = ili9341.CONTROLLER_ST7789
+	return ili9341.NewILI9341WithConfig(spiConn, pinDC, pinRST, config)
+}
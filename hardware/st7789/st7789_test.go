@@ -0,0 +1,187 @@
+package st7789
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/marksaravi/devices-go/colors"
+	"github.com/marksaravi/devices-go/devices/display"
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+	"github.com/marksaravi/devices-go/hardware/ili9341"
+	"github.com/marksaravi/devices-go/hardware/ili9341/ili9341test"
+	"github.com/marksaravi/devices-go/hardware/spi/spitest"
+)
+
+func testConfig(t *testing.T, size PanelSize, rotation ili9341.Rotation) ili9341.Config {
+	t.Helper()
+	config, err := DefaultConfig(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ResetDelay = 0
+	config.SleepDelay = 0
+	config.Rotation = rotation
+	return config
+}
+
+func newTestPanel(t *testing.T, config ili9341.Config) (*ili9341.Device, *ili9341test.Panel, *spitest.SPI) {
+	t.Helper()
+	dc := gpiotest.NewPin(gpio.Low)
+	panel := ili9341test.NewPanel(dc)
+	panel.BGRFilter = false
+	conn := spitest.NewSPI(dc, panel)
+	dev, err := NewST7789WithConfig(conn, dc, gpiotest.NewPin(gpio.Low), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, panel, conn
+}
+
+func TestNewST7789(t *testing.T) {
+	dc := gpiotest.NewPin(gpio.Low)
+	conn := spitest.NewSPI(dc, nil)
+	if _, err := NewST7789(conn, dc, gpiotest.NewPin(gpio.Low), PANEL_240X240, ili9341.ROTATION_270); err != nil {
+		t.Fatal(err)
+	}
+	cmds := conn.Commands()
+	if cmds[0].Cmd != 0x11 || cmds[3].Cmd != 0xB2 {
+		t.Errorf("wanted the ST7789 init sequence, got %x %x", cmds[0].Cmd, cmds[3].Cmd)
+	}
+	if cmds[1].Cmd != 0x3A || cmds[2].Cmd != 0x36 || !bytes.Equal(cmds[2].Data, []byte{0x00}) {
+		t.Errorf("wanted pixel format and memory access control 00 with RGB order, got %x, %x %x", cmds[1].Cmd, cmds[2].Cmd, cmds[2].Data)
+	}
+	if last := cmds[len(cmds)-1]; last.Cmd != 0x29 {
+		t.Errorf("wanted display on last, got %x", last.Cmd)
+	}
+
+	if _, err := NewST7789(conn, dc, gpiotest.NewPin(gpio.Low), PanelSize(3), ili9341.ROTATION_0); err == nil {
+		t.Errorf("wanted error for invalid panel size")
+	}
+	config := testConfig(t, PANEL_135X240, ili9341.ROTATION_0)
+	config.ColumnOffset = 106
+	if _, err := NewST7789WithConfig(conn, dc, gpiotest.NewPin(gpio.Low), config); err == nil {
+		t.Errorf("wanted error for a panel outside the memory")
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	tests := []struct {
+		size          PanelSize
+		rotation      ili9341.Rotation
+		width, height int
+		x, y          int // column and page address of the screen pixel 0,0
+		col, row      int // where the screen pixel 1,2 is in the memory
+	}{
+		{PANEL_240X240, ili9341.ROTATION_0, 240, 240, 0, 0, 237, 1},
+		{PANEL_240X240, ili9341.ROTATION_90, 240, 240, 0, 80, 238, 237},
+		{PANEL_240X240, ili9341.ROTATION_180, 240, 240, 80, 0, 2, 238},
+		{PANEL_240X240, ili9341.ROTATION_270, 240, 240, 0, 0, 1, 2},
+		{PANEL_240X320, ili9341.ROTATION_0, 320, 240, 0, 0, 237, 1},
+		{PANEL_240X320, ili9341.ROTATION_90, 240, 320, 0, 0, 238, 317},
+		{PANEL_135X240, ili9341.ROTATION_0, 240, 135, 40, 53, 184, 41},
+		{PANEL_135X240, ili9341.ROTATION_90, 135, 240, 53, 40, 185, 277},
+		{PANEL_135X240, ili9341.ROTATION_180, 240, 135, 40, 52, 54, 278},
+		{PANEL_135X240, ili9341.ROTATION_270, 135, 240, 52, 40, 53, 42},
+	}
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	white := color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	for _, test := range tests {
+		config := testConfig(t, test.size, test.rotation)
+		dev, panel, conn := newTestPanel(t, config)
+		if dev.ScreenWidth() != test.width || dev.ScreenHeight() != test.height {
+			t.Errorf("size %d rotation %d: wanted %dx%d, got %dx%d", test.size, test.rotation, test.width, test.height, dev.ScreenWidth(), dev.ScreenHeight())
+		}
+		d := display.NewRGBDisplay(dev)
+		d.SetBackgroundColor(colors.WHITE)
+		d.Clear()
+		d.Update()
+		conn.Reset()
+		d.SetColor(colors.RED)
+		d.Pixel(1, 2)
+		d.Update()
+		cmds := conn.Commands()
+		wantX := []byte{byte(test.x >> 8), byte(test.x)}
+		wantY := []byte{byte(test.y >> 8), byte(test.y)}
+		if cmds[0].Cmd != 0x2A || !bytes.Equal(cmds[0].Data[:2], wantX) || cmds[1].Cmd != 0x2B || !bytes.Equal(cmds[1].Data[:2], wantY) {
+			t.Errorf("size %d rotation %d: wanted the window at %d,%d, got %x %x %x %x", test.size, test.rotation, test.x, test.y, cmds[0].Cmd, cmds[0].Data, cmds[1].Cmd, cmds[1].Data)
+		}
+		gram := panel.GRAM()
+		if got := gram.At(test.col, test.row); got != red {
+			t.Errorf("size %d rotation %d: wanted red at %d,%d, got %v", test.size, test.rotation, test.col, test.row, got)
+		}
+		// the rest of the panel is white and the memory outside it is untouched
+		cols, rows := config.Height, config.Width
+		visible := image.Rect(config.ColumnOffset, config.RowOffset, config.ColumnOffset+cols, config.RowOffset+rows)
+		painted := 0
+		for row := 0; row < ili9341test.GRAM_HEIGHT; row++ {
+			for col := 0; col < ili9341test.GRAM_WIDTH; col++ {
+				if c := gram.At(col, row); c == white || c == red {
+					painted++
+					if !image.Pt(col, row).In(visible) {
+						t.Fatalf("size %d rotation %d: wanted nothing at %d,%d outside the panel", test.size, test.rotation, col, row)
+					}
+				}
+			}
+		}
+		if painted != cols*rows {
+			t.Errorf("size %d rotation %d: wanted %d pixels on the panel, got %d", test.size, test.rotation, cols*rows, painted)
+		}
+	}
+}
+
+func TestSetRotation(t *testing.T) {
+	dev, panel, _ := newTestPanel(t, testConfig(t, PANEL_240X240, ili9341.ROTATION_0))
+	d := display.NewRGBDisplay(dev)
+	d.SetBackgroundColor(colors.WHITE)
+	d.Clear()
+	d.SetColor(colors.BLUE)
+	d.FillRectangle(10, 20, 100, 60)
+	d.Update()
+	before := panel.GRAM()
+
+	if err := dev.SetRotation(ili9341.ROTATION_90); err != nil {
+		t.Fatal(err)
+	}
+	dev.Update()
+	after := panel.GRAM()
+	for row := 0; row < 240; row++ {
+		for col := 0; col < 240; col++ {
+			if before.At(col, row) != after.At(col, row) {
+				t.Fatalf("at %d,%d, the picture changed after rotation", col, row)
+			}
+		}
+	}
+}
+
+func TestReadGRAM(t *testing.T) {
+	dev, _, _ := newTestPanel(t, testConfig(t, PANEL_135X240, ili9341.ROTATION_90))
+	dev.FillRect(0, 0, 134, 239, colors.BLACK)
+	dev.FillRect(10, 20, 11, 21, colors.RED)
+	dev.Update()
+	pixels := make([]colors.RGB565, 4)
+	if err := dev.ReadGRAM(10, 20, 2, 2, pixels); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range pixels {
+		if c != colors.RGB565(0xF800) {
+			t.Errorf("at %d, wanted red, got %x", i, c)
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	dev, _, _ := newTestPanel(t, testConfig(t, PANEL_240X240, ili9341.ROTATION_0))
+	if err := dev.Scroll(10); err == nil {
+		t.Errorf("wanted error for scrolling a panel shorter than the memory")
+	}
+	dev, panel, _ := newTestPanel(t, testConfig(t, PANEL_240X320, ili9341.ROTATION_0))
+	if err := dev.Scroll(10); err != nil {
+		t.Fatal(err)
+	}
+	if params := panel.Params(0x37); !bytes.Equal(params, []byte{0x00, 0x0A}) {
+		t.Errorf("wanted scroll start 10, got %x", params)
+	}
+}
@@ -0,0 +1,328 @@
+// Package xpt2046 drives the XPT2046 resistive touch controller found on most
+// ILI9341 modules. It shares the SPI bus with the display on its own chip select
+// and a clock of at most 2MHz.
+package xpt2046
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/spi"
+)
+
+// MAX_VALUE is the largest raw reading of the 12 bit converter.
+const MAX_VALUE = 0xFFF
+
+// Control bytes of 12 bit differential conversions. The power down bits are 0,
+// which keeps the pen interrupt enabled between conversions.
+const (
+	cmd_read_x  byte = 0xD0
+	cmd_read_y  byte = 0x90
+	cmd_read_z1 byte = 0xB0
+	cmd_read_z2 byte = 0xC0
+)
+
+type Filter int
+
+// Filter combines the readings of a sample. FILTER_MEDIAN ignores the spikes of
+// a bouncing contact and FILTER_AVERAGE smooths a steady one.
+const (
+	FILTER_MEDIAN  Filter = 0
+	FILTER_AVERAGE Filter = 1
+)
+
+type EventType int
+
+const (
+	EVENT_DOWN EventType = 0
+	EVENT_MOVE EventType = 1
+	EVENT_UP   EventType = 2
+)
+
+// Sample is a raw position from 0 to MAX_VALUE and the pressure, which grows
+// with the force and is 0 without a touch.
+type Sample struct {
+	X int
+	Y int
+	Z int
+}
+
+// Event is a change of the touch. An EVENT_UP has the last position.
+type Event struct {
+	Type EventType
+	Sample
+	Time time.Time
+}
+
+type Config struct {
+	Samples           int // readings of each value for a sample
+	Filter            Filter
+	PressureThreshold int // minimum pressure of a touch
+	MoveThreshold     int // smallest change of X or Y, in raw units, sent as an EVENT_MOVE
+	PollInterval      time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Samples:           5,
+		Filter:            FILTER_MEDIAN,
+		PressureThreshold: 400,
+		MoveThreshold:     12,
+		PollInterval:      10 * time.Millisecond,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Samples <= 0 {
+		return errors.New("invalid number of samples")
+	}
+	if c.Filter != FILTER_MEDIAN && c.Filter != FILTER_AVERAGE {
+		return errors.New("invalid filter")
+	}
+	if c.PressureThreshold <= 0 || c.PressureThreshold > MAX_VALUE {
+		return errors.New("invalid pressure threshold")
+	}
+	if c.MoveThreshold < 1 || c.MoveThreshold > MAX_VALUE {
+		return errors.New("invalid move threshold")
+	}
+	if c.PollInterval <= 0 {
+		return errors.New("invalid poll interval")
+	}
+	return nil
+}
+
+type device struct {
+	mu       sync.Mutex // protects the SPI bus and its buffers
+	conn     spi.SPI
+	pinIRQ   gpio.GPIOPinIn // low while the panel is touched, optional
+	config   Config
+	txBuf    [3]byte
+	rxBuf    [3]byte
+	readings []int
+	streamMu sync.Mutex // serializes the starts of the event streams
+	stop     func()     // stops the running event stream
+	errMu    sync.Mutex
+	err      error
+}
+
+func NewXPT2046(spiConn spi.SPI, pinIRQ gpio.GPIOPinIn) (*device, error) {
+	return NewXPT2046WithConfig(spiConn, pinIRQ, DefaultConfig())
+}
+
+// NewXPT2046WithConfig creates the driver, pinIRQ is the PENIRQ output and can
+// be nil, the pressure then tells if the panel is touched.
+func NewXPT2046WithConfig(spiConn spi.SPI, pinIRQ gpio.GPIOPinIn, config Config) (*device, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &device{
+		conn:     spiConn,
+		pinIRQ:   pinIRQ,
+		config:   config,
+		readings: make([]int, config.Samples),
+	}, nil
+}
+
+// ReadRaw reads each value once without filtering.
+func (dev *device) ReadRaw() (Sample, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.sample(dev.convert)
+}
+
+// Read reads Config.Samples times each value and combines them with Config.Filter.
+// The position is only meaningful when the pressure reaches Config.PressureThreshold.
+func (dev *device) Read() (Sample, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.sample(dev.filtered)
+}
+
+func (dev *device) sample(read func(cmd byte) (int, error)) (Sample, error) {
+	var s Sample
+	var z1, z2 int
+	var err error
+	if z1, err = read(cmd_read_z1); err != nil {
+		return Sample{}, err
+	}
+	if z2, err = read(cmd_read_z2); err != nil {
+		return Sample{}, err
+	}
+	if s.Y, err = read(cmd_read_y); err != nil {
+		return Sample{}, err
+	}
+	if s.X, err = read(cmd_read_x); err != nil {
+		return Sample{}, err
+	}
+	s.Z = pressure(z1, z2)
+	return s, nil
+}
+
+// IsTouched tells if the panel is touched, from the IRQ pin if there is one and
+// from the pressure otherwise.
+func (dev *device) IsTouched() (bool, error) {
+	if dev.pinIRQ != nil {
+		return dev.pinIRQ.Read() == gpio.Low, nil
+	}
+	s, err := dev.Read()
+	if err != nil {
+		return false, err
+	}
+	return s.Z >= dev.config.PressureThreshold, nil
+}
+
+// touched reads the sample of a touch. Without an IRQ pin, the pressure of the
+// sample tells if the panel is touched, so a single Read is needed.
+func (dev *device) touched() (bool, Sample, error) {
+	if dev.pinIRQ != nil && dev.pinIRQ.Read() == gpio.High {
+		return false, Sample{}, nil
+	}
+	s, err := dev.Read()
+	if err != nil {
+		return false, Sample{}, err
+	}
+	// the pen can be lifted during the readings
+	if s.Z < dev.config.PressureThreshold {
+		return false, Sample{}, nil
+	}
+	return true, s, nil
+}
+
+// Events polls the panel every Config.PollInterval and sends the touch events
+// until the context is done or a read fails. The channel is closed then and
+// Err returns the error of the failed read. A new call stops the events of the
+// previous one, the panel has a single stream of events.
+func (dev *device) Events(ctx context.Context) <-chan Event {
+	dev.streamMu.Lock()
+	defer dev.streamMu.Unlock()
+	if dev.stop != nil {
+		dev.stop()
+	}
+	dev.errMu.Lock()
+	dev.err = nil
+	dev.errMu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	dev.stop = func() {
+		cancel()
+		<-done
+	}
+	events := make(chan Event)
+	go func() {
+		defer close(done)
+		defer close(events)
+		defer cancel()
+		if err := dev.poll(ctx, events); err != nil {
+			dev.errMu.Lock()
+			dev.err = err
+			dev.errMu.Unlock()
+		}
+	}()
+	return events
+}
+
+// Err returns the error which stopped the events of the last stream.
+func (dev *device) Err() error {
+	dev.errMu.Lock()
+	defer dev.errMu.Unlock()
+	return dev.err
+}
+
+func (dev *device) poll(ctx context.Context, events chan<- Event) error {
+	ticker := time.NewTicker(dev.config.PollInterval)
+	defer ticker.Stop()
+	touched := false
+	var sent, last Sample // the sample of the last event and the last sample
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		down, s, err := dev.touched()
+		if err != nil {
+			return err
+		}
+		var e Event
+		switch {
+		case down && !touched:
+			e = Event{Type: EVENT_DOWN, Sample: s}
+		case down && dev.moved(sent, s):
+			e = Event{Type: EVENT_MOVE, Sample: s}
+		case !down && touched:
+			e = Event{Type: EVENT_UP, Sample: last}
+		default:
+			// a still finger moves by less than Config.MoveThreshold
+			if down {
+				last = s
+			}
+			continue
+		}
+		touched = down
+		if down {
+			sent, last = s, s
+		}
+		e.Time = time.Now()
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// moved tells if the touch moved by Config.MoveThreshold since the sample of the last event.
+func (dev *device) moved(from, to Sample) bool {
+	return abs(to.X-from.X) >= dev.config.MoveThreshold || abs(to.Y-from.Y) >= dev.config.MoveThreshold
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// filtered converts Config.Samples times and combines the readings.
+func (dev *device) filtered(cmd byte) (int, error) {
+	for i := range dev.readings {
+		value, err := dev.convert(cmd)
+		if err != nil {
+			return 0, err
+		}
+		dev.readings[i] = value
+	}
+	if dev.config.Filter == FILTER_AVERAGE {
+		sum := 0
+		for _, value := range dev.readings {
+			sum += value
+		}
+		return (sum + len(dev.readings)/2) / len(dev.readings), nil
+	}
+	sort.Ints(dev.readings)
+	return dev.readings[len(dev.readings)/2], nil
+}
+
+// convert sends a control byte and clocks out the 12 bit result.
+func (dev *device) convert(cmd byte) (int, error) {
+	dev.txBuf = [3]byte{cmd, 0, 0}
+	if err := dev.conn.Tx(dev.txBuf[:], dev.rxBuf[:]); err != nil {
+		return 0, err
+	}
+	return (int(dev.rxBuf[1])<<8 | int(dev.rxBuf[2])) >> 3 & MAX_VALUE, nil
+}
+
+// pressure grows with the force on the panel: Z1 rises and Z2 falls as the
+// contact resistance drops.
+func pressure(z1, z2 int) int {
+	z := z1 + MAX_VALUE - z2
+	if z1 == 0 || z < 0 {
+		return 0
+	}
+	return z
+}
@@ -0,0 +1,288 @@
+package xpt2046
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marksaravi/devices-go/hardware/gpio"
+	"github.com/marksaravi/devices-go/hardware/gpio/gpiotest"
+	"github.com/marksaravi/devices-go/hardware/spi/spitest"
+)
+
+// fakeController answers the conversions with the values of each control byte.
+// A queue of values is consumed one per conversion and its last value repeats.
+type fakeController struct {
+	mu      sync.Mutex
+	values  map[byte][]int
+	pending map[byte][]int // a touch which starts with the next sample
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{values: make(map[byte][]int)}
+}
+
+func (c *fakeController) set(cmd byte, values ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[cmd] = values
+}
+
+// touch sets a steady touch, or no touch with a pressure of 0, from the next
+// sample on so that a sample never mixes two touches.
+func (c *fakeController) touch(x, y, z int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = map[byte][]int{
+		cmd_read_x:  {x},
+		cmd_read_y:  {y},
+		cmd_read_z1: {z},
+		cmd_read_z2: {MAX_VALUE},
+	}
+}
+
+func (c *fakeController) Tx(w, r []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// a sample starts with Z1
+	if w[0] == cmd_read_z1 && c.pending != nil {
+		c.values, c.pending = c.pending, nil
+	}
+	queue := c.values[w[0]]
+	value := 0
+	if len(queue) > 0 {
+		value = queue[0]
+		if len(queue) > 1 {
+			c.values[w[0]] = queue[1:]
+		}
+	}
+	r[0] = 0
+	r[1] = byte(value >> 5)
+	r[2] = byte(value << 3)
+	return nil
+}
+
+func newTestDevice(t *testing.T, config Config, irq gpio.GPIOPinIn) (*device, *fakeController, *spitest.SPI) {
+	t.Helper()
+	controller := newFakeController()
+	conn := spitest.NewSPI(nil, controller)
+	dev, err := NewXPT2046WithConfig(conn, irq, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev, controller, conn
+}
+
+func TestReadRaw(t *testing.T) {
+	dev, controller, conn := newTestDevice(t, DefaultConfig(), nil)
+	controller.set(cmd_read_x, 1000)
+	controller.set(cmd_read_y, 2000)
+	controller.set(cmd_read_z1, 500)
+	controller.set(cmd_read_z2, 3000)
+	s, err := dev.ReadRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Sample{X: 1000, Y: 2000, Z: 1595}); s != want {
+		t.Errorf("wanted %v, got %v", want, s)
+	}
+	transactions := conn.Transactions()
+	want := []byte{0xB0, 0xC0, 0x90, 0xD0}
+	if len(transactions) != len(want) {
+		t.Fatalf("wanted %d conversions, got %d", len(want), len(transactions))
+	}
+	for i, tr := range transactions {
+		if len(tr.W) != 3 || tr.W[0] != want[i] {
+			t.Errorf("at %d, wanted %x 00 00, got %x", i, want[i], tr.W)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		want   int
+	}{
+		{FILTER_MEDIAN, 1001},
+		{FILTER_AVERAGE, 1600},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.Filter = test.filter
+		dev, controller, conn := newTestDevice(t, config, nil)
+		controller.set(cmd_read_y, 500)
+		controller.set(cmd_read_z1, 1000)
+		controller.set(cmd_read_x, 1000, 4000, 1002, 998, 1001)
+		s, err := dev.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.X != test.want || s.Y != 500 {
+			t.Errorf("filter %d: wanted %d,500, got %d,%d", test.filter, test.want, s.X, s.Y)
+		}
+		if n := len(conn.Transactions()); n != 20 {
+			t.Errorf("filter %d: wanted 20 conversions, got %d", test.filter, n)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Samples = 0
+	if _, err := NewXPT2046WithConfig(spitest.NewSPI(nil, nil), nil, config); err == nil {
+		t.Errorf("wanted error for invalid number of samples")
+	}
+	config = DefaultConfig()
+	config.MoveThreshold = 0
+	if _, err := NewXPT2046WithConfig(spitest.NewSPI(nil, nil), nil, config); err == nil {
+		t.Errorf("wanted error for invalid move threshold")
+	}
+}
+
+func TestIsTouched(t *testing.T) {
+	irq := gpiotest.NewPin(gpio.High)
+	dev, _, conn := newTestDevice(t, DefaultConfig(), irq)
+	if touched, _ := dev.IsTouched(); touched {
+		t.Errorf("wanted no touch with IRQ high")
+	}
+	irq.Set(gpio.Low)
+	if touched, _ := dev.IsTouched(); !touched {
+		t.Errorf("wanted a touch with IRQ low")
+	}
+	if n := len(conn.Transactions()); n != 0 {
+		t.Errorf("wanted no conversions with the IRQ pin, got %d", n)
+	}
+
+	dev, controller, _ := newTestDevice(t, DefaultConfig(), nil)
+	controller.touch(100, 100, 300)
+	if touched, _ := dev.IsTouched(); touched {
+		t.Errorf("wanted no touch below the pressure threshold")
+	}
+	controller.touch(100, 100, 800)
+	if touched, _ := dev.IsTouched(); !touched {
+		t.Errorf("wanted a touch above the pressure threshold")
+	}
+}
+
+func TestTouched(t *testing.T) {
+	config := DefaultConfig()
+	tests := []struct {
+		name    string
+		irq     gpio.GPIOPinIn
+		z       int
+		want    bool
+		samples int
+	}{
+		{"IRQ high", gpiotest.NewPin(gpio.High), 1000, false, 0},
+		{"IRQ low", gpiotest.NewPin(gpio.Low), 1000, true, 1},
+		{"no IRQ, touched", nil, 1000, true, 1},
+		{"no IRQ, light touch", nil, config.PressureThreshold - 1, false, 1},
+	}
+	for _, test := range tests {
+		dev, controller, conn := newTestDevice(t, config, test.irq)
+		controller.touch(100, 200, test.z)
+		touched, s, err := dev.touched()
+		if err != nil || touched != test.want {
+			t.Errorf("%s: wanted touched %v, got %v, %v", test.name, test.want, touched, err)
+		}
+		if test.want && s != (Sample{100, 200, 1000}) {
+			t.Errorf("%s: wanted the sample of the touch, got %v", test.name, s)
+		}
+		if n := len(conn.Transactions()); n != test.samples*4*config.Samples {
+			t.Errorf("%s: wanted %d conversions, got %d", test.name, test.samples*4*config.Samples, n)
+		}
+	}
+}
+
+// nextEvent waits for an event, a zero want is no event for some polls.
+func nextEvent(t *testing.T, events <-chan Event, want Event) {
+	t.Helper()
+	if want == (Event{}) {
+		select {
+		case e := <-events:
+			t.Errorf("wanted no event, got %v", e)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return
+	}
+	select {
+	case e := <-events:
+		if e.Type != want.Type || e.Sample != want.Sample || e.Time.IsZero() {
+			t.Errorf("wanted %v, got %v", want, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wanted %v, got no event", want)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	config := DefaultConfig()
+	config.PollInterval = time.Millisecond
+	dev, controller, _ := newTestDevice(t, config, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controller.touch(100, 200, 1000)
+	events := dev.Events(ctx)
+
+	nextEvent(t, events, Event{Type: EVENT_DOWN, Sample: Sample{100, 200, 1000}})
+	// a still finger jitters below the move threshold
+	controller.touch(100+config.MoveThreshold-1, 200-config.MoveThreshold+1, 1000)
+	nextEvent(t, events, Event{})
+	controller.touch(150, 200, 1000)
+	nextEvent(t, events, Event{Type: EVENT_MOVE, Sample: Sample{150, 200, 1000}})
+	controller.touch(155, 201, 1000)
+	nextEvent(t, events, Event{})
+	controller.touch(0, 0, 0)
+	nextEvent(t, events, Event{Type: EVENT_UP, Sample: Sample{155, 201, 1000}})
+	cancel()
+	for range events {
+	}
+	if err := dev.Err(); err != nil {
+		t.Errorf("wanted no error after cancel, got %v", err)
+	}
+}
+
+func TestEventsRestart(t *testing.T) {
+	config := DefaultConfig()
+	config.PollInterval = time.Millisecond
+	dev, controller, conn := newTestDevice(t, config, nil)
+	errTx := errors.New("tx failed")
+	conn.FailAfter(10, errTx)
+	for range dev.Events(context.Background()) {
+	}
+	conn.FailAfter(0, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := dev.Events(ctx)
+	if err := dev.Err(); err != nil {
+		t.Errorf("wanted the error cleared by a new stream, got %v", err)
+	}
+	second := dev.Events(ctx)
+	select {
+	case _, ok := <-first:
+		if ok {
+			t.Errorf("wanted the first stream stopped")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wanted the first stream closed")
+	}
+	controller.touch(100, 200, 1000)
+	nextEvent(t, second, Event{Type: EVENT_DOWN, Sample: Sample{100, 200, 1000}})
+	cancel()
+	for range second {
+	}
+}
+
+func TestEventsError(t *testing.T) {
+	config := DefaultConfig()
+	config.PollInterval = time.Millisecond
+	dev, _, conn := newTestDevice(t, config, nil)
+	errTx := errors.New("tx failed")
+	conn.FailAfter(10, errTx)
+	for range dev.Events(context.Background()) {
+	}
+	if err := dev.Err(); err != errTx {
+		t.Errorf("wanted %v, got %v", errTx, err)
+	}
+}